@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// A scan target: the name the user gave and the address we actually dial
+type hostEntry struct {
+	name    string
+	address string
+}
+
+// Address family label for an IP literal, empty for hostnames
+func addressFamily(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	if addr.Unmap().Is4() {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// Strips the brackets from IPv6 literals like [::1]
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// Every A and AAAA record for host, IPv4 first. IP literals come back unchanged.
+func lookupAllAddresses(ctx context.Context, resolver *net.Resolver, host string) ([]string, error) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{host}, nil
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+
+	var v4, v6 []string
+	seen := make(map[netip.Addr]bool)
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		if addr.Is4() {
+			v4 = append(v4, addr.String())
+		} else {
+			v6 = append(v6, addr.String())
+		}
+	}
+
+	return append(v4, v6...), nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bumped whenever the state file layout changes
+const checkpointVersion = 1
+
+// How often a running scan saves its progress
+const checkpointInterval = 5 * time.Second
+
+// Saved state of a scan, written periodically by WithCheckpoint and read back by LoadCheckpoint
+type Checkpoint struct {
+	Version  int
+	Command  []string // What started the scan, so a front end can rebuild it
+	Ports    string   // Every port being scanned, as a port spec
+	Saved    time.Time
+	Complete bool // Every host finished, there's nothing left to probe
+	Hosts    []HostCheckpoint
+}
+
+// Progress on one scanned address
+type HostCheckpoint struct {
+	Name      string
+	Address   string
+	Probed    string     `json:",omitempty"` // Ports already probed, as a port spec
+	Open      []PortInfo `json:",omitempty"`
+	Names     []string   `json:",omitempty"`
+	Finished  bool       // Reported as done, Open is the full result
+	Down      bool       `json:",omitempty"`
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+// Reads a state file written by a checkpointed scan
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s has unsupported version %d", path, cp.Version)
+	}
+
+	return cp, nil
+}
+
+// Tracks what a scan has done so far and saves it for resuming
+type scanJournal struct {
+	path    string // Empty to only skip resumed work
+	command []string
+	ports   string
+
+	mu    sync.Mutex
+	hosts map[string]*journalHost // By address
+	order []string                // Addresses in the order they were first seen
+	dirty bool
+
+	// Work done before this run, never changes once loaded
+	resumed map[string]*journalHost
+}
+
+// Journal entry for one address
+type journalHost struct {
+	name      string
+	probed    map[PortTarget]bool
+	open      []PortInfo
+	names     []string
+	finished  bool
+	down      bool
+	timestamp time.Time
+	duration  time.Duration
+}
+
+// Creates the journal for a scan, nil when there's nothing to save or resume
+func newScanJournal(path string, command []string, ports []PortTarget, resume *Checkpoint) (*scanJournal, error) {
+	if path == "" && resume == nil {
+		return nil, nil
+	}
+
+	j := &scanJournal{
+		path:    path,
+		command: command,
+		ports:   formatPortSpec(ports),
+		hosts:   make(map[string]*journalHost),
+		resumed: make(map[string]*journalHost),
+	}
+	if resume == nil {
+		return j, nil
+	}
+
+	// Probed ports are only skipped when the port list is the same
+	if resume.Ports != j.ports {
+		return nil, fmt.Errorf("checkpoint was saved scanning ports %s, not %s", resume.Ports, j.ports)
+	}
+
+	// Carry the earlier work over so the next save still has it
+	for _, saved := range resume.Hosts {
+		h := &journalHost{
+			name:      saved.Name,
+			probed:    make(map[PortTarget]bool),
+			open:      saved.Open,
+			names:     saved.Names,
+			finished:  saved.Finished,
+			down:      saved.Down,
+			timestamp: saved.Timestamp,
+			duration:  saved.Duration,
+		}
+		if saved.Probed != "" {
+			targets, err := parsePortSpec(saved.Probed)
+			if err != nil {
+				return nil, fmt.Errorf("invalid checkpoint entry for %s: %w", saved.Address, err)
+			}
+			for _, target := range targets {
+				h.probed[target] = true
+			}
+		}
+
+		if _, exists := j.hosts[saved.Address]; !exists {
+			j.order = append(j.order, saved.Address)
+		}
+		j.hosts[saved.Address] = h
+
+		// Keep a separate copy, the live entry keeps changing
+		frozen := *h
+		frozen.probed = make(map[PortTarget]bool, len(h.probed))
+		for target := range h.probed {
+			frozen.probed[target] = true
+		}
+		frozen.open = append([]PortInfo(nil), h.open...)
+		j.resumed[saved.Address] = &frozen
+	}
+
+	return j, nil
+}
+
+// Reports whether an earlier run already probed target on address
+func (j *scanJournal) wasProbed(address string, target PortTarget) bool {
+	if j == nil {
+		return false
+	}
+	h, exists := j.resumed[address]
+	return exists && (h.finished || h.probed[target])
+}
+
+// Open ports an earlier run found on address
+func (j *scanJournal) resumedOpen(address string) []PortInfo {
+	if j == nil {
+		return nil
+	}
+	if h, exists := j.resumed[address]; exists {
+		return append([]PortInfo(nil), h.open...)
+	}
+	return nil
+}
+
+// Result an earlier run reported for address, false if it never finished
+func (j *scanJournal) resumedResult(address string) (ScanResult, bool) {
+	if j == nil {
+		return ScanResult{}, false
+	}
+	h, exists := j.resumed[address]
+	if !exists || !h.finished {
+		return ScanResult{}, false
+	}
+
+	return ScanResult{
+		Host:      h.name,
+		Address:   address,
+		Family:    addressFamily(address),
+		Names:     h.names,
+		Ports:     append([]PortInfo(nil), h.open...),
+		Timestamp: h.timestamp,
+		Duration:  h.duration,
+		Down:      h.down,
+		Resumed:   true,
+	}, true
+}
+
+// Gets or creates the entry for address, called with the lock held
+func (j *scanJournal) host(name, address string) *journalHost {
+	h, exists := j.hosts[address]
+	if !exists {
+		h = &journalHost{name: name, probed: make(map[PortTarget]bool)}
+		j.hosts[address] = h
+		j.order = append(j.order, address)
+	}
+	return h
+}
+
+// Records a finished probe
+func (j *scanJournal) recordProbe(result PortResult) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A local failure says nothing about the port, it's probed again on resume
+	if result.Status == StatusError {
+		return
+	}
+
+	h := j.host(result.Host, result.Address)
+	target := PortTarget{Port: result.Port, Proto: result.Proto}
+	if h.probed[target] {
+		return
+	}
+	h.probed[target] = true
+	if result.Status == StatusOpen {
+		h.open = append(h.open, PortInfo{
+			Port:    result.Port,
+			Proto:   result.Proto,
+			Service: identifyService(result.Port, result.Banner),
+			Banner:  result.Banner,
+		})
+	}
+	j.dirty = true
+}
+
+// Records a host's final result
+func (j *scanJournal) finishHost(result ScanResult) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	h := j.host(result.Host, result.Address)
+	h.finished = true
+	h.down = result.Down
+	h.open = append([]PortInfo(nil), result.Ports...)
+	h.names = result.Names
+	h.timestamp = result.Timestamp
+	h.duration = result.Duration
+	h.probed = make(map[PortTarget]bool) // Finished covers every port
+	j.dirty = true
+}
+
+// Saves every interval until the returned func is called, which saves
+// one last time and reports the first save that failed
+func (j *scanJournal) start() func(complete bool) error {
+	if j == nil || j.path == "" {
+		return func(bool) error { return nil }
+	}
+
+	stop := make(chan bool)
+	done := make(chan error)
+	go func() {
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+
+		var firstErr error
+		for {
+			select {
+			case complete := <-stop:
+				if err := j.save(complete); firstErr == nil {
+					firstErr = err
+				}
+				done <- firstErr
+				return
+			case <-ticker.C:
+				if err := j.save(false); firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}()
+
+	return func(complete bool) error {
+		stop <- complete
+		return <-done
+	}
+}
+
+// Writes the state file if anything changed, replacing it in one step
+// so a crash mid-write never leaves a torn file behind
+func (j *scanJournal) save(complete bool) error {
+	j.mu.Lock()
+	if !j.dirty && !complete {
+		j.mu.Unlock()
+		return nil
+	}
+	cp := j.snapshot(complete)
+	j.dirty = false
+	j.mu.Unlock()
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %w", err)
+	}
+
+	// Never write into a temp file someone else left there
+	temp := j.path + ".tmp"
+	os.Remove(temp)
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(temp, j.path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Copies the journal into a Checkpoint, called with the lock held
+func (j *scanJournal) snapshot(complete bool) *Checkpoint {
+	cp := &Checkpoint{
+		Version:  checkpointVersion,
+		Command:  j.command,
+		Ports:    j.ports,
+		Saved:    time.Now(),
+		Complete: complete,
+		Hosts:    make([]HostCheckpoint, 0, len(j.order)),
+	}
+
+	for _, address := range j.order {
+		h := j.hosts[address]
+		entry := HostCheckpoint{
+			Name:      h.name,
+			Address:   address,
+			Open:      append([]PortInfo(nil), h.open...),
+			Names:     h.names,
+			Finished:  h.finished,
+			Down:      h.down,
+			Timestamp: h.timestamp,
+			Duration:  h.duration,
+		}
+
+		if !h.finished {
+			probed := make([]PortTarget, 0, len(h.probed))
+			for target := range h.probed {
+				probed = append(probed, target)
+			}
+			entry.Probed = formatPortSpec(probed)
+		}
+
+		// Same order every save, makes the file easy to diff
+		sort.Slice(entry.Open, func(a, b int) bool {
+			if entry.Open[a].Proto != entry.Open[b].Proto {
+				return entry.Open[a].Proto < entry.Open[b].Proto
+			}
+			return entry.Open[a].Port < entry.Open[b].Port
+		})
+
+		cp.Hosts = append(cp.Hosts, entry)
+	}
+
+	return cp
+}
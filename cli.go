@@ -0,0 +1,644 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes of the command line mode
+const (
+	exitOK        = 0   // Everything as expected
+	exitFound     = 1   // Unexpected open ports, a host that's down or no banner
+	exitUsage     = 2   // Bad command or flags
+	exitError     = 3   // The scan failed or a host couldn't be scanned
+	exitCancelled = 130 // Stopped with Ctrl+C
+)
+
+// A REPL option that scan and range also take as a flag
+type cliOption struct {
+	flag   string
+	option string // Name in the REPL's name=value options
+	value  string // What a switch sets the option to, empty for flags that take a value
+	usage  string
+}
+
+// Flags shared by scan and range, see the REPL help for what they do
+var cliScanOptions = []cliOption{
+	{"ports", "ports", "", "ports to scan, e.g. 22,80,443,8000-8100,U:53"},
+	{"top", "top", "", "scan the n most common ports instead"},
+	{"proto", "proto", "", "scan the ports over tcp, udp or both"},
+	{"dns", "dns", "", "resolve names with this DNS server"},
+	{"source", "source", "", "send probes from this local address"},
+	{"iface", "iface", "", "send probes from this network interface's addresses"},
+	{"sport", "sport", "", "source port or range, e.g. 40000-40100"},
+	{"proxy", "proxy", "", "connect through a socks5://, socks5h:// or http:// proxy"},
+	{"random", "random", "", "probe in a shuffled order from this seed"},
+	{"rate", "rate", "", "limit to n probes per second"},
+	{"hostconns", "hostconns", "", "limit concurrent connections to any one host"},
+	{"delay", "delay", "", "wait at least this many ms between probes to a host"},
+	{"adaptive", "adaptive", "", "tune timeouts from round trips, min-max in ms"},
+	{"retries", "retries", "", "try ports that time out up to n more times"},
+	{"backoff", "backoff", "", "ms to wait before the first retry"},
+	{"banners", "banners", "", "grab banners from up to n open ports at once, 0 to skip"},
+	{"bannertimeout", "bannertimeout", "", "ms to wait for a banner"},
+	{"checkpoint", "checkpoint", "", "save progress to this file for -resume"},
+	{"all-addrs", "addrs", "all", "scan every IPv4 and IPv6 address of a hostname"},
+	{"no-rdns", "rdns", "off", "skip reverse DNS lookups of IP targets"},
+	{"reuse", "reuse", "on", "read banners on the probe connection"},
+	{"autothreads", "autothreads", "on", "tune how many threads run at once, up to -threads"},
+	{"raise-nofile", "nofile", "raise", "raise the open file limit if it's too low for the threads"},
+}
+
+// Flags only range takes
+var cliRangeOptions = []cliOption{
+	{"hosts", "hosts", "", "scan up to n hosts at the same time"},
+	{"exclude", "exclude", "", "skip these hosts"},
+	{"discover", "discover", "", "find live hosts with tcp, icmp, arp or none"},
+	{"aliveports", "aliveports", "", "ports tcp discovery connects to"},
+}
+
+// Flags that pick how ping and banner connect
+var cliDialOptions = []cliOption{
+	{"dns", "dns", "", "resolve names with this DNS server"},
+	{"source", "source", "", "send probes from this local address"},
+	{"iface", "iface", "", "send probes from this network interface's addresses"},
+	{"sport", "sport", "", "source port or range, e.g. 40000-40100"},
+	{"proxy", "proxy", "", "connect through a socks5://, socks5h:// or http:// proxy"},
+}
+
+// Runs one command given on the command line and returns the exit code
+func runCommandLine(args []string) int {
+	switch strings.ToLower(args[0]) {
+	case "scan":
+		return runCLIScan(args, false)
+	case "range":
+		return runCLIScan(args, true)
+	case "ping":
+		return runCLIPing(args)
+	case "banner":
+		return runCLIBanner(args)
+	case "web":
+		return runCLIWeb(args)
+	case "report":
+		return runCLIReport(args)
+	case "help", "-h", "-help", "--help":
+		printCLIUsage(os.Stdout)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "portscanner: unknown command %q\n\n", args[0])
+		printCLIUsage(os.Stderr)
+		return exitUsage
+	}
+}
+
+// Lists the commands
+func printCLIUsage(w io.Writer) {
+	fmt.Fprint(w, `Usage: portscanner [command] [flags] [arguments]
+
+Without a command the interactive interface starts.
+
+Commands:
+  scan <host>...       Scan hosts for open ports
+  range <targets>      Scan ranges, CIDRs and lists, skipping dead hosts
+  ping <host>...       Check whether hosts are up
+  banner <host> <port> Grab a service banner
+  web                  Run the web interface
+  report <file>        Print a JSON result or checkpoint file in another format
+
+Run portscanner <command> -h for the flags of a command.
+
+Exit codes:
+  0    Done, nothing unexpected
+  1    Open ports outside -expect, a host that's down, or no banner
+  2    Bad command or flags
+  3    The scan failed or a host couldn't be scanned
+  130  Interrupted
+`)
+}
+
+// Creates the flag set for a command, usage explains the arguments
+func newCLIFlagSet(command, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: portscanner %s %s\n\nFlags:\n", command, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parses flags wherever they are among the arguments, returning the
+// arguments. Everything after a -- terminator is an argument.
+func parseCLIFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Exit code for a flag parsing error, -h isn't a failure
+func cliUsageExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+// Registers REPL options as flags, the returned func collects the ones set
+func addCLIOptions(fs *flag.FlagSet, options []cliOption) func() map[string]string {
+	values := make(map[string]*string)
+	switches := make(map[string]*bool)
+	for _, option := range options {
+		if option.value == "" {
+			values[option.flag] = fs.String(option.flag, "", option.usage)
+		} else {
+			switches[option.flag] = fs.Bool(option.flag, false, option.usage)
+		}
+	}
+
+	return func() map[string]string {
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+
+		opts := make(map[string]string)
+		for _, option := range options {
+			switch {
+			case !set[option.flag]:
+				// Left to the default
+			case option.value == "":
+				opts[option.option] = *values[option.flag]
+			case *switches[option.flag]:
+				opts[option.option] = option.value
+			}
+		}
+		return opts
+	}
+}
+
+// Where and how a command writes its results
+type cliOutput struct {
+	format   string
+	path     string
+	expected map[PortTarget]bool // Ports allowed to be open, nil when not checking
+}
+
+// Registers -format, -o and -expect
+func addCLIOutputFlags(fs *flag.FlagSet) *cliOutput {
+	output := &cliOutput{}
+	fs.StringVar(&output.format, "format", "text", "output format: text, json or csv")
+	fs.StringVar(&output.path, "o", "", "write results to this file instead of stdout")
+	fs.Func("expect", "ports allowed to be open, any other open port exits with 1 (none for no ports)", func(spec string) error {
+		output.expected = make(map[PortTarget]bool)
+		if spec == "" || strings.EqualFold(spec, "none") {
+			return nil
+		}
+		targets, err := parsePortSpec(spec)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			output.expected[target] = true
+		}
+		return nil
+	})
+	return output
+}
+
+// Checks the format before any work is done
+func (o *cliOutput) check() error {
+	return writeScanReport(io.Discard, o.format, scanReport{})
+}
+
+// Writes the report to the output file or stdout
+func (o *cliOutput) write(report scanReport) error {
+	if o.path == "" {
+		return writeScanReport(os.Stdout, o.format, report)
+	}
+
+	file, err := os.Create(o.path)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	if err := writeScanReport(file, o.format, report); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	return file.Close()
+}
+
+// Reports open ports outside -expect on stderr, true if there were any
+func (o *cliOutput) unexpected(report scanReport) bool {
+	if o.expected == nil {
+		return false
+	}
+
+	found := false
+	for _, host := range report.Hosts {
+		for _, info := range host.Open {
+			if !o.expected[PortTarget{Port: info.Port, Proto: info.Proto}] {
+				fmt.Fprintf(os.Stderr, "Unexpected open port: %s %d/%s (%s)\n", host.Host, info.Port, info.Proto, info.Service)
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// Runs the scan or range command
+func runCLIScan(args []string, isRange bool) int {
+	command := args[0]
+	usage := "[flags] <host>..."
+	options := cliScanOptions
+	threads, timeout, endPort := 100, 500*time.Millisecond, 1000
+	if isRange {
+		usage = "[flags] <targets>"
+		options = append(append([]cliOption{}, cliScanOptions...), cliRangeOptions...)
+		endPort = 100
+	}
+
+	fs := newCLIFlagSet(command, usage)
+	fs.IntVar(&threads, "threads", threads, "how many ports to probe at once")
+	fs.DurationVar(&timeout, "timeout", timeout, "connection timeout per port")
+	resumePath := fs.String("resume", "", "continue the scan saved in this checkpoint file")
+	showStats := fs.Bool("stats", false, "print scan statistics to stderr")
+	collect := addCLIOptions(fs, options)
+	output := addCLIOutputFlags(fs)
+
+	hosts, err := parseCLIFlags(fs, args[1:])
+	if err != nil {
+		return cliUsageExit(err)
+	}
+	if len(hosts) == 0 || (isRange && len(hosts) > 1) {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := output.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+
+	// The REPL's option handling does the rest
+	opts := collect()
+	ports, err := uiPortTargets(opts, 1, endPort)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+	tuning, err := uiTuningOptions(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+
+	// Like the REPL's random=, but stdout is kept for the results
+	seed, shuffled := int64(0), false
+	if value, ok := opts["random"]; ok {
+		shuffled = true
+		if seed, err = strconv.ParseInt(value, 10, 64); err != nil {
+			seed = time.Now().UnixNano()
+			fmt.Fprintf(os.Stderr, "Using random seed %d\n", seed)
+		}
+	}
+
+	// Plain scans treat every host as up, ranges check first
+	var source HostSource
+	maxHosts := 8
+	if isRange {
+		discovery, err := uiDiscoveryOptions(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+			return exitUsage
+		}
+		tuning = append(tuning, discovery...)
+
+		if value, ok := opts["hosts"]; ok {
+			maxHosts, err = strconv.Atoi(value)
+			if err != nil || maxHosts < 1 {
+				fmt.Fprintf(os.Stderr, "portscanner: invalid hosts value %q\n", value)
+				return exitUsage
+			}
+		}
+
+		targets, err := parseTargetSpec(hosts[0], opts["exclude"])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "portscanner: error parsing targets: %v\n", err)
+			return exitUsage
+		}
+		source = targets.Hosts()
+		if shuffled {
+			source = targets.ShuffledHosts(seed)
+		}
+	} else {
+		for i, host := range hosts {
+			hosts[i] = normalizeHost(host)
+		}
+		if shuffled {
+			rand.New(rand.NewSource(seed)).Shuffle(len(hosts), func(i, j int) {
+				hosts[i], hosts[j] = hosts[j], hosts[i]
+			})
+		}
+		source = hostList(hosts)
+	}
+
+	// Keep saving to the file being resumed unless told otherwise
+	var resume *Checkpoint
+	if *resumePath != "" {
+		resume, err = LoadCheckpoint(*resumePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+			return exitError
+		}
+		if _, ok := opts["checkpoint"]; !ok {
+			opts["checkpoint"] = *resumePath
+		}
+	}
+
+	// Ctrl+C stops the scan, whatever finished is still written out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	scanOptions := []ScannerOption{
+		WithPorts(ports),
+		WithThreads(threads),
+		WithTimeout(timeout),
+		WithMaxHosts(maxHosts),
+		WithProgress(false),
+		WithContext(ctx),
+	}
+
+	if shuffled {
+		scanOptions = append(scanOptions, WithRandomOrder(seed))
+	}
+	saved := append([]string{"portscanner"}, args...)
+	scanOptions = append(scanOptions, uiCheckpointOptions(opts, saved, resume)...)
+	scanner := NewMultiScanner(source, append(scanOptions, tuning...)...)
+
+	started := time.Now()
+	results := []ScanResult{}
+	for result := range scanner.Results() {
+		results = append(results, result)
+	}
+	scanErr := scanner.Err()
+
+	// Hosts finish in any order, keep the output stable from run to run
+	sort.SliceStable(results, func(i, j int) bool {
+		a, errA := netip.ParseAddr(results[i].Address)
+		b, errB := netip.ParseAddr(results[j].Address)
+		if errA != nil || errB != nil {
+			return results[i].Host < results[j].Host
+		}
+		return a.Less(b)
+	})
+
+	report := newScanReport(saved, started, results)
+	if err := output.write(report); err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitError
+	}
+	if *showStats {
+		for _, line := range formatScanStats(scanner.Stats()) {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	// Failures trump findings
+	if scanErr != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", scanErr)
+		if errors.Is(scanErr, ErrCancelled) {
+			if path := opts["checkpoint"]; path != "" {
+				fmt.Fprintf(os.Stderr, "Progress saved to %s, continue with -resume %s\n", path, path)
+			}
+			return exitCancelled
+		}
+		return exitError
+	}
+
+	// Ports that only failed say nothing, so they can't pass as closed
+	code := exitOK
+	if output.unexpected(report) {
+		code = exitFound
+	}
+	if stats := scanner.Stats(); stats.Probed > 0 && stats.Statuses[StatusError] == stats.Probed {
+		fmt.Fprintf(os.Stderr, "portscanner: all %d probes failed, see -stats\n", stats.Probed)
+		code = exitError
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			code = exitError
+			continue
+		}
+		for _, failure := range result.Stats.Failures {
+			// Unreachable ports still count as filtered
+			if failure.Kind == ErrHostUnreachable {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "portscanner: %d probes to %s failed: %v\n", failure.Count, formatHostLabel(result), failure.First)
+			code = exitError
+		}
+	}
+	return code
+}
+
+// Runs the ping command, exits with 1 if any host is down
+func runCLIPing(args []string) int {
+	fs := newCLIFlagSet(args[0], "[flags] <host>...")
+	timeout := fs.Duration("timeout", 2*time.Second, "how long each discovery probe waits")
+	methods := fs.String("discover", "", "how to check: tcp, icmp, arp or several like icmp,tcp (default tcp)")
+	alivePorts := fs.String("aliveports", "", "ports tcp discovery connects to (default 80,443,22,3389)")
+	collect := addCLIOptions(fs, cliDialOptions)
+
+	hosts, err := parseCLIFlags(fs, args[1:])
+	if err != nil {
+		return cliUsageExit(err)
+	}
+	if len(hosts) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	probes, err := parseDiscovery(*methods, *alivePorts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+	opts := collect()
+	dialer, err := uiDialer(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	code := exitOK
+	dns := uiDNSCache(opts)
+	for _, host := range hosts {
+		host = normalizeHost(host)
+
+		// ICMP and ARP need an address to work with
+		entries, err := dns.hostEntries(ctx, host, false)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", host, err)
+			code = exitError
+			continue
+		}
+
+		method, err := discoverHost(ctx, dialer, entries[0].address, *timeout, probes)
+		switch {
+		case ctx.Err() != nil:
+			return exitCancelled
+		case method != "":
+			fmt.Printf("%s: up (%s)\n", host, method)
+		case err != nil:
+			fmt.Printf("%s: down (%v)\n", host, err)
+		default:
+			fmt.Printf("%s: down\n", host)
+		}
+		if method == "" && code == exitOK {
+			code = exitFound
+		}
+	}
+	return code
+}
+
+// Runs the banner command, exits with 1 if the port has nothing to say
+func runCLIBanner(args []string) int {
+	fs := newCLIFlagSet(args[0], "[flags] <host> <port>")
+	timeout := fs.Duration("timeout", 5*time.Second, "how long to wait to connect and for the banner")
+	collect := addCLIOptions(fs, cliDialOptions)
+
+	positional, err := parseCLIFlags(fs, args[1:])
+	if err != nil {
+		return cliUsageExit(err)
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return exitUsage
+	}
+	host := normalizeHost(positional[0])
+	port, err := strconv.Atoi(positional[1])
+	if err != nil || port < 1 || port > 65535 {
+		fmt.Fprintln(os.Stderr, "portscanner: port must be a number between 1 and 65535")
+		return exitUsage
+	}
+
+	dialer, err := uiDialer(collect())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+
+	// A service that never speaks up just has no banner
+	banner, err := grabBanner(context.Background(), dialer, host, port, *timeout, *timeout)
+	if err != nil && !isTimeoutError(err) {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitError
+	}
+	if banner == "" {
+		fmt.Fprintln(os.Stderr, "No banner (the port may be closed or the service waits for the client)")
+		return exitFound
+	}
+	fmt.Println(banner)
+	return exitOK
+}
+
+// Runs the web interface until it fails
+func runCLIWeb(args []string) int {
+	fs := newCLIFlagSet(args[0], "[flags]")
+	addr := fs.String("addr", ":8080", "address to listen on")
+	if _, err := parseCLIFlags(fs, args[1:]); err != nil {
+		return cliUsageExit(err)
+	}
+
+	if err := startWebServer(*addr); err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// Runs the report command on a JSON result file or a checkpoint
+func runCLIReport(args []string) int {
+	fs := newCLIFlagSet(args[0], "[flags] <file>")
+	output := addCLIOutputFlags(fs)
+
+	positional, err := parseCLIFlags(fs, args[1:])
+	if err != nil {
+		return cliUsageExit(err)
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := output.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitUsage
+	}
+
+	report, err := loadScanReport(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitError
+	}
+	if err := output.write(report); err != nil {
+		fmt.Fprintf(os.Stderr, "portscanner: %v\n", err)
+		return exitError
+	}
+
+	if output.unexpected(report) {
+		return exitFound
+	}
+	return exitOK
+}
+
+// Reads a file written by -format json, or a checkpoint
+func loadScanReport(path string) (scanReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scanReport{}, fmt.Errorf("error reading report: %w", err)
+	}
+
+	// Only checkpoints carry a version
+	var header struct{ Version int }
+	if err := json.Unmarshal(data, &header); err != nil {
+		return scanReport{}, fmt.Errorf("invalid report %s: %w", path, err)
+	}
+	if header.Version != 0 {
+		cp, err := LoadCheckpoint(path)
+		if err != nil {
+			return scanReport{}, err
+		}
+		return checkpointReport(cp), nil
+	}
+
+	report := scanReport{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return scanReport{}, fmt.Errorf("invalid report %s: %w", path, err)
+	}
+	return report, nil
+}
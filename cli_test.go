@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestParseCLIFlags(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		ports      string
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, ""},
+		{[]string{"-ports", "22", "a"}, []string{"a"}, "22"},
+		{[]string{"a", "-ports", "22", "b", "-v"}, []string{"a", "b"}, "22"},
+		{[]string{"a", "--", "-ports", "22"}, []string{"a", "-ports", "22"}, ""},
+		{[]string{"-v", "--", "--"}, []string{"--"}, ""},
+		{[]string{"a", "--"}, []string{"a"}, ""},
+		{[]string{}, []string{}, ""},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		ports := fs.String("ports", "", "")
+		fs.Bool("v", false, "")
+
+		positional, err := parseCLIFlags(fs, tt.args)
+		if err != nil {
+			t.Errorf("parseCLIFlags(%q) failed: %v", tt.args, err)
+			continue
+		}
+		if !slices.Equal(positional, tt.positional) || *ports != tt.ports {
+			t.Errorf("parseCLIFlags(%q) = %q with -ports %q, want %q with %q", tt.args, positional, *ports, tt.positional, tt.ports)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := parseCLIFlags(fs, []string{"a", "-bogus"}); err == nil {
+		t.Error("an unknown flag after an argument was accepted")
+	}
+}
+
+func TestRunCLIScanExitCodes(t *testing.T) {
+	open, closed := localPorts(t, "SSH-2.0-StandIn\r\n")
+	ports := strconv.Itoa(open) + "," + strconv.Itoa(closed)
+	out := filepath.Join(t.TempDir(), "out.txt")
+	scan := func(flags ...string) []string {
+		return append([]string{"scan", "-ports", ports, "-no-rdns", "-banners", "0", "-o", out}, flags...)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"expected open port", scan("-expect", strconv.Itoa(open), "127.0.0.1"), exitOK},
+		{"unexpected open port", scan("-expect", "none", "127.0.0.1"), exitFound},
+		{"help", []string{"scan", "-h"}, exitOK},
+		{"unknown flag", scan("-bogus", "127.0.0.1"), exitUsage},
+		{"no hosts", scan(), exitUsage},
+		{"bad format", scan("-format", "xml", "127.0.0.1"), exitUsage},
+		{"bad ports", []string{"scan", "-ports", "100-50", "127.0.0.1"}, exitUsage},
+		{"dead proxy", scan("-proxy", "socks5://127.0.0.1:"+strconv.Itoa(closed), "127.0.0.1"), exitError},
+		{"every probe failed", scan("-proxy", socks5StandIn(t, 0x01), "127.0.0.1"), exitError},
+		{"missing checkpoint", scan("-resume", filepath.Join(t.TempDir(), "missing.json"), "127.0.0.1"), exitError},
+	}
+	for _, tt := range tests {
+		if got := runCommandLine(tt.args); got != tt.want {
+			t.Errorf("%s: exit code %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunCLIReportJSON(t *testing.T) {
+	open, closed := localPorts(t, "")
+	path := filepath.Join(t.TempDir(), "scan.json")
+	args := []string{"scan", "-ports", strconv.Itoa(open) + "," + strconv.Itoa(closed) + ",U:" + strconv.Itoa(closed),
+		"-no-rdns", "-banners", "0", "-format", "json", "-o", path, "127.0.0.1"}
+	if code := runCommandLine(args); code != exitOK {
+		t.Fatalf("scan exited with %d", code)
+	}
+
+	report, err := loadScanReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Hosts) != 1 || len(report.Hosts[0].Open) != 1 {
+		t.Fatalf("report = %+v, want one host with one open port", report)
+	}
+	if info := report.Hosts[0].Open[0]; info.Port != open || info.Proto != ProtoTCP {
+		t.Errorf("open port read back as %d/%s, want %d/tcp", info.Port, info.Proto, open)
+	}
+
+	// The report command checks a saved result like a live scan
+	out := filepath.Join(t.TempDir(), "report.txt")
+	if code := runCommandLine([]string{"report", "-expect", "U:" + strconv.Itoa(open), "-o", out, path}); code != exitFound {
+		t.Errorf("report with the port expected over UDP exited with %d, want %d", code, exitFound)
+	}
+	if code := runCommandLine([]string{"report", "-expect", strconv.Itoa(open), "-o", out, path}); code != exitOK {
+		t.Errorf("report with the port expected exited with %d, want %d", code, exitOK)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Descriptors left for everything that isn't a probe: stdio, DNS, the web UI, checkpoints
+const fdReserve = 32
+
+// Gap between two cuts, so one shortage hitting many workers at once only counts once
+const gateShrinkCooldown = 100 * time.Millisecond
+
+// How often a probe goes back in the queue for running out of sockets before it's reported
+const maxExhaustedRetries = 8
+
+// Pause before a probe that ran out of sockets tries again, grows with each attempt
+const exhaustedBackoff = 50 * time.Millisecond
+
+// Workers an auto-tuned scan starts with
+const autoStartThreads = 10
+
+// Fewest probes the tuner wants to see before changing the worker count
+const autoWindowMin = 20
+
+// Periods it takes to grow from nothing to the thread count once past slow start
+const autoSteps = 50
+
+// Rise in the share of timed-out attempts that counts as congestion
+const autoTimeoutMargin = 0.05
+
+// Connect times this many times the usual count as congestion
+const autoRTTInflation = 2.0
+
+// Replies needed in a window before its connect times are trusted
+const autoMinRTTSamples = 5
+
+// Caps how many workers probe at once, below the worker count when the
+// system is short of file descriptors or, when auto-tuning, the network
+// looks congested
+type workerGate struct {
+	mu         sync.Mutex
+	limit      int
+	ceiling    int // Never tuned above this
+	peak       int
+	active     int
+	freed      chan struct{} // Closed whenever a slot frees up or the limit changes
+	lastShrink time.Time
+
+	// AIMD tuning, see Observe
+	auto        bool
+	slowStart   bool          // Doubling until the first sign of congestion
+	round       time.Duration // Longest a probe can take
+	periodStart time.Time     // When the limit last changed
+	window      gateWindow
+	baseRatio   float64       // Lowest timeout ratio seen, -1 until the first window
+	baseRTT     time.Duration // Usual connect time, smoothed over uncongested windows
+	cut         *gateCut      // Last cut, until the next window shows whether it helped
+}
+
+// Outcomes of probes started early in the current period
+type gateWindow struct {
+	probes     int
+	attempts   int
+	timeouts   int
+	rttSamples int
+	rttTotal   time.Duration
+}
+
+// What the network looked like just before a cut
+type gateCut struct {
+	limit int
+	ratio float64
+	rtt   time.Duration
+}
+
+// Creates a gate letting limit workers through at once
+func newWorkerGate(limit int) *workerGate {
+	return &workerGate{limit: limit, ceiling: limit, peak: limit, freed: make(chan struct{})}
+}
+
+// Creates a gate that starts with a few workers and tunes the count up to
+// ceiling, round is the longest a single probe can take
+func newAutoWorkerGate(ceiling int, round time.Duration) *workerGate {
+	g := newWorkerGate(min(autoStartThreads, ceiling))
+	g.ceiling = ceiling
+	g.auto = true
+	g.slowStart = true
+	g.round = round
+	g.periodStart = time.Now()
+	g.baseRatio = -1
+	return g
+}
+
+// Waits for a free slot, call release when done
+func (g *workerGate) Acquire(ctx context.Context) (func(), error) {
+	for {
+		g.mu.Lock()
+		if g.active < g.limit {
+			g.active++
+			g.mu.Unlock()
+			return g.release, nil
+		}
+		freed := g.freed
+		g.mu.Unlock()
+
+		select {
+		case <-freed:
+			// Try again
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Gives a slot back and wakes the waiters
+func (g *workerGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	g.wake()
+}
+
+// Wakes every waiter, called with the lock held
+func (g *workerGate) wake() {
+	close(g.freed)
+	g.freed = make(chan struct{})
+}
+
+// Halves the limit after the system ran out of sockets, false if it was
+// already cut moments ago or can't go lower. Tuning never goes back above it.
+func (g *workerGate) Shrink() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.limit <= 1 || time.Since(g.lastShrink) < gateShrinkCooldown {
+		return false
+	}
+	g.ceiling = g.limit / 2
+	g.cut = nil
+	g.setLimit(g.ceiling)
+	g.lastShrink = time.Now()
+	return true
+}
+
+// Feeds a finished TCP probe that started at started to the tuner.
+//
+// Each time the limit changes the tuner watches the probes started in the
+// next round, waiting another round so even the ones that time out are in.
+// More timeouts or slower connects than usual then halve the worker count,
+// anything else adds to it (doubling it until the first cut).
+func (g *workerGate) Observe(result PortResult, started time.Time) {
+	if !g.auto || result.Proto != ProtoTCP || result.Status == StatusError {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !started.Before(g.periodStart) && started.Before(g.periodStart.Add(g.round)) {
+		// Every retry was a timeout, and so was the last attempt if it never got an answer
+		w := &g.window
+		w.probes++
+		w.attempts += result.Attempts
+		w.timeouts += result.Attempts - 1
+		if isTimeoutError(result.Err) {
+			w.timeouts++
+		}
+		if result.RTT > 0 {
+			w.rttSamples++
+			w.rttTotal += result.RTT
+		}
+	}
+
+	if time.Since(g.periodStart) < 2*g.round {
+		return
+	}
+	if g.window.probes < autoWindowMin {
+		// Too few to go on, watch for another round
+		g.periodStart = time.Now()
+		g.window = gateWindow{}
+		return
+	}
+	g.adjust()
+}
+
+// Picks the next worker count from the window that just closed, called with the lock held
+func (g *workerGate) adjust() {
+	w := g.window
+	ratio := float64(w.timeouts) / float64(w.attempts)
+	var rtt time.Duration
+	if w.rttSamples >= autoMinRTTSamples {
+		rtt = w.rttTotal / time.Duration(w.rttSamples)
+	}
+
+	// A cut that didn't help means the target is just like that, e.g. a
+	// firewall dropping a run of ports. Undo it and take this as normal.
+	if cut := g.cut; cut != nil {
+		g.cut = nil
+		helped := ratio < cut.ratio-autoTimeoutMargin || (rtt > 0 && cut.rtt > 0 && rtt < cut.rtt*3/4)
+		if !helped {
+			g.baseRatio, g.baseRTT = ratio, rtt
+			g.setLimit(cut.limit)
+			return
+		}
+	}
+
+	congested := g.baseRatio >= 0 && ratio > g.baseRatio+autoTimeoutMargin
+	if rtt > 0 && g.baseRTT > 0 && float64(rtt) > autoRTTInflation*float64(g.baseRTT) {
+		congested = true
+	}
+	if g.baseRatio < 0 || ratio < g.baseRatio {
+		g.baseRatio = ratio
+	}
+	switch {
+	case rtt == 0 || congested:
+		// Keep the usual connect time free of queueing delay
+	case g.baseRTT == 0:
+		g.baseRTT = rtt
+	default:
+		g.baseRTT += (rtt - g.baseRTT) / 8
+	}
+
+	switch {
+	case congested && g.limit > 1:
+		g.cut = &gateCut{limit: g.limit, ratio: ratio, rtt: rtt}
+		g.slowStart = false
+		g.setLimit(g.limit / 2)
+	case congested:
+		// Can't go lower, watch another round
+		g.setLimit(1)
+	case g.slowStart:
+		g.setLimit(g.limit * 2)
+	default:
+		step := g.ceiling / autoSteps
+		if step < 1 {
+			step = 1
+		}
+		g.setLimit(g.limit + step)
+	}
+}
+
+// Changes the limit within 1..ceiling and starts a new period, called with the lock held
+func (g *workerGate) setLimit(limit int) {
+	g.limit = min(limit, g.ceiling)
+	if g.limit < 1 {
+		g.limit = 1
+	}
+	if g.limit > g.peak {
+		g.peak = g.limit
+	}
+	g.periodStart = time.Now()
+	g.window = gateWindow{}
+	g.wake()
+}
+
+// Workers allowed to probe at once, 0 before the first scan
+func (g *workerGate) Limit() int {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// Most workers allowed to probe at once so far
+func (g *workerGate) Peak() int {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.peak
+}
+
+// Works out how many probes can be in flight without running out of file
+// descriptors, raising the soft limit first when asked to
+func (s *Scanner) limitConcurrency() {
+	need := uint64(s.threads + s.fdOverhead() + fdReserve)
+	limit, err := openFileLimit()
+	if err == nil && limit < need && s.raiseFileLimit {
+		if raised, err := raiseOpenFileLimit(); err == nil {
+			limit = raised
+		}
+	}
+
+	// Unknown limit, leave it to the EMFILE handling
+	allowed := s.threads
+	s.fileLimit = 0
+	if err == nil {
+		s.fileLimit = limit
+		if limit < need {
+			allowed = int(limit) - s.fdOverhead() - fdReserve
+		}
+	}
+
+	// Every probe in flight holds a source port, so a range caps them too
+	if s.sourcePortMin != 0 {
+		allowed = min(allowed, s.sourcePortMax-s.sourcePortMin+1)
+	}
+	if allowed < 1 {
+		allowed = 1
+	}
+
+	if s.autoThreads {
+		s.gate = newAutoWorkerGate(allowed, s.longestProbe())
+	} else {
+		s.gate = newWorkerGate(allowed)
+	}
+}
+
+// Sockets the scan may hold besides the probes themselves
+func (s *Scanner) fdOverhead() int {
+	// Each banner worker holds a connection, and with reuse one more is
+	// being read while others wait in the hand-off queue
+	overhead := s.bannerWorkers
+	if s.reuseConns {
+		overhead += 2 * s.bannerWorkers
+	}
+
+	// Liveness checks try every TCP discovery port at once, for each host
+	if s.aliveTimeout > 0 {
+		probes := s.discovery
+		if len(probes) == 0 {
+			probes = defaultDiscovery
+		}
+		perHost := 1
+		for _, probe := range probes {
+			if tcp, ok := probe.(TCPDiscovery); ok && len(tcp.Ports) > perHost {
+				perHost = len(tcp.Ports)
+			}
+		}
+		overhead += s.maxHosts * perHost
+	}
+
+	return overhead
+}
+
+// Longest a probe can take, retries included
+func (s *Scanner) longestProbe() time.Duration {
+	timeout := s.timeout
+	if s.adaptive && s.maxTimeout > timeout {
+		timeout = s.maxTimeout
+	}
+
+	longest := timeout
+	for retry := 0; retry < s.retries; retry++ {
+		longest += s.retryBackoff<<retry + timeout
+	}
+	return longest
+}
+
+// Probes one job. When the system runs out of sockets it cuts the number
+// of workers probing at once and tries the port again after a pause,
+// instead of reporting a state the port never showed. False if the scan
+// was cancelled.
+func (s *Scanner) probeJob(job probeJob) (PortResult, bool) {
+	for attempt := 1; ; attempt++ {
+		result, started, err := s.probe(job.address, job.target)
+		if err != nil {
+			return PortResult{}, false
+		}
+		result.Host = job.host
+		if s.ctx.Err() != nil {
+			// Cancelled mid-probe, result is meaningless
+			closeProbeConn(&result)
+			return result, false
+		}
+
+		if !isResourceExhausted(result.Err) {
+			s.gate.Observe(result, started)
+			return result, true
+		}
+		if attempt >= maxExhaustedRetries {
+			return result, true
+		}
+		s.gate.Shrink()
+		s.requeued.Add(1)
+		if sleepContext(s.ctx, exhaustedBackoff*time.Duration(attempt)) != nil {
+			return result, false
+		}
+	}
+}
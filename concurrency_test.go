@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Window of probes answered in rtt each, timeouts of which never answered
+func observed(probes, timeouts int, rtt time.Duration) gateWindow {
+	return gateWindow{
+		probes:     probes,
+		attempts:   probes,
+		timeouts:   timeouts,
+		rttSamples: probes - timeouts,
+		rttTotal:   time.Duration(probes-timeouts) * rtt,
+	}
+}
+
+// Closes a window on the gate and returns the limit it picked
+func closeWindow(g *workerGate, w gateWindow) int {
+	g.mu.Lock()
+	g.window = w
+	g.adjust()
+	g.mu.Unlock()
+	return g.Limit()
+}
+
+func TestWorkerGateSlowStart(t *testing.T) {
+	g := newAutoWorkerGate(100, time.Second)
+	if got := g.Limit(); got != autoStartThreads {
+		t.Fatalf("starting limit = %d, want %d", got, autoStartThreads)
+	}
+
+	for _, want := range []int{20, 40, 80, 100, 100} {
+		if got := closeWindow(g, observed(40, 0, 10*time.Millisecond)); got != want {
+			t.Fatalf("limit after a clean window = %d, want %d", got, want)
+		}
+	}
+	if g.Peak() != 100 {
+		t.Errorf("peak = %d, want 100", g.Peak())
+	}
+}
+
+func TestWorkerGateCut(t *testing.T) {
+	g := newAutoWorkerGate(100, time.Second)
+	closeWindow(g, observed(40, 0, 10*time.Millisecond))
+
+	// Timeouts well above the usual halve the limit and end slow start
+	if got := closeWindow(g, observed(40, 10, 10*time.Millisecond)); got != 10 {
+		t.Fatalf("limit after timeouts rose = %d, want 10", got)
+	}
+
+	// The cut helped, so growth is additive from here on
+	if got := closeWindow(g, observed(40, 0, 10*time.Millisecond)); got != 12 {
+		t.Errorf("limit after the cut helped = %d, want 10 + 100/%d", got, autoSteps)
+	}
+
+	// Connect times over twice the usual count as congestion too
+	if got := closeWindow(g, observed(40, 0, 25*time.Millisecond)); got != 6 {
+		t.Errorf("limit after connect times rose = %d, want 6", got)
+	}
+}
+
+func TestWorkerGateUndoCut(t *testing.T) {
+	g := newAutoWorkerGate(100, time.Second)
+	closeWindow(g, observed(40, 0, 10*time.Millisecond))
+	closeWindow(g, observed(40, 10, 10*time.Millisecond))
+
+	// Just as many timeouts with half the workers: the target drops those
+	// ports whatever we do, so the cut is undone and this becomes the usual
+	if got := closeWindow(g, observed(40, 10, 10*time.Millisecond)); got != 20 {
+		t.Fatalf("limit after a cut that didn't help = %d, want 20", got)
+	}
+	if got := closeWindow(g, observed(40, 10, 10*time.Millisecond)); got != 22 {
+		t.Errorf("limit once those timeouts are usual = %d, want 22", got)
+	}
+}
+
+func TestWorkerGateShrinkCeiling(t *testing.T) {
+	g := newAutoWorkerGate(100, time.Second)
+	closeWindow(g, observed(40, 0, 10*time.Millisecond))
+
+	if !g.Shrink() || g.Limit() != 10 {
+		t.Fatalf("limit after Shrink = %d, want 10", g.Limit())
+	}
+	if g.Shrink() {
+		t.Error("a second Shrink within the cooldown cut again")
+	}
+
+	// Running out of sockets caps tuning for the rest of the scan
+	for i := 0; i < 3; i++ {
+		if got := closeWindow(g, observed(40, 0, 10*time.Millisecond)); got != 10 {
+			t.Fatalf("limit after Shrink and a clean window = %d, want the ceiling of 10", got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Opens connections the way net.Dialer does. Everything that touches the
+// network goes through one, so proxies, rate limits, recording or a
+// simulated network can be slotted in.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// The scanner's own dialer: a custom Dialer or a bound source, optionally
+// through a proxy. The zero value dials directly, like net.Dialer.
+type scanDialer struct {
+	base   Dialer // From WithDialer, nil to dial from source
+	source *sourceBinding
+	proxy  *proxyConfig
+}
+
+// Builds the dialer for a scan, any part may be nil
+func newScanDialer(base Dialer, source *sourceBinding, proxy *proxyConfig) *scanDialer {
+	return &scanDialer{base: base, source: source, proxy: proxy}
+}
+
+// Connects to address, through the proxy if there is one
+func (d *scanDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.proxy != nil {
+		return d.proxy.dial(ctx, d.direct(), network, address)
+	}
+	return d.direct().DialContext(ctx, network, address)
+}
+
+// Makes sure the proxy, if there is one, takes connections
+func (d *scanDialer) checkProxy(ctx context.Context, timeout time.Duration) error {
+	if d.proxy == nil {
+		return nil
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := d.direct().DialContext(dialCtx, "tcp", d.proxy.address)
+	if err != nil {
+		return &ScanError{Message: "can't reach proxy " + d.proxy.address, Kind: ErrProxyFailure, Err: err}
+	}
+	conn.Close()
+	return nil
+}
+
+// Dialer for direct connections, which the proxy is reached through too
+func (d *scanDialer) direct() Dialer {
+	if d.base != nil {
+		return d.base
+	}
+	return d.source // A nil binding dials like net.Dialer
+}
+
+// Works out whether traffic through dialer really goes out on the local
+// network, and from which source. ICMP and ARP discovery can only work when
+// it does, not through a proxy or a simulated network.
+func systemSource(dialer Dialer) (*sourceBinding, bool) {
+	switch d := dialer.(type) {
+	case nil, *net.Dialer:
+		return nil, true
+	case *sourceBinding:
+		return d, true
+	case *scanDialer:
+		if d.proxy == nil {
+			return systemSource(d.direct())
+		}
+	}
+	return nil, false
+}
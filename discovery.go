@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// Interface for pluggable host discovery
+type DiscoveryProbe interface {
+	Alive(ctx context.Context, dialer Dialer, host string, timeout time.Duration) (bool, error)
+	Name() string
+}
+
+// Ports tried by TCP discovery unless told otherwise
+var defaultDiscoveryPorts = []int{80, 443, 22, 3389}
+
+// Probes used when a scan asks for discovery without choosing any
+var defaultDiscovery = []DiscoveryProbe{TCPDiscovery{Ports: defaultDiscoveryPorts}}
+
+// TCP connect discovery. Any answer counts, a refusal is a RST from a live host.
+type TCPDiscovery struct {
+	Ports []int
+}
+
+func (d TCPDiscovery) Alive(ctx context.Context, dialer Dialer, host string, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Try every port at once so a dead host costs one timeout, not one per port
+	answers := make(chan bool, len(d.Ports))
+	for _, port := range d.Ports {
+		go func(port int) {
+			status, _ := probePort(ctx, dialer, host, port, timeout)
+			answers <- status == StatusOpen || status == StatusClosed
+		}(port)
+	}
+
+	for range d.Ports {
+		if <-answers {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d TCPDiscovery) Name() string {
+	return "tcp"
+}
+
+// ICMP echo discovery using unprivileged ping sockets, see pingHost
+type ICMPDiscovery struct{}
+
+func (d ICMPDiscovery) Alive(ctx context.Context, dialer Dialer, host string, timeout time.Duration) (bool, error) {
+	return pingHost(ctx, dialer, host, timeout)
+}
+
+func (d ICMPDiscovery) Name() string {
+	return "icmp"
+}
+
+// Neighbour table discovery for hosts on a directly attached IPv4 subnet
+type ARPDiscovery struct{}
+
+// Where Linux exposes the neighbour table
+const arpTablePath = "/proc/net/arp"
+
+func (d ARPDiscovery) Alive(ctx context.Context, dialer Dialer, host string, timeout time.Duration) (bool, error) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !addr.Unmap().Is4() {
+		return false, fmt.Errorf("ARP discovery needs an IPv4 address, got %q", host)
+	}
+	ip := addr.Unmap().String()
+	source, local := systemSource(dialer)
+	if !local {
+		return false, fmt.Errorf("ARP discovery needs the local network, not a proxy or custom dialer")
+	}
+
+	// Already known?
+	found, err := arpEntry(ip)
+	if err != nil || found {
+		return found, err
+	}
+
+	// Nudge the kernel into resolving the address by sending it a datagram
+	conn, err := source.DialContext(ctx, "udp4", net.JoinHostPort(ip, "9"))
+	if err != nil {
+		return false, nil
+	}
+	conn.Write([]byte{0})
+	conn.Close()
+
+	// Watch the table until the entry completes or we give up
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if sleepContext(ctx, 50*time.Millisecond) != nil {
+			return false, ctx.Err()
+		}
+		found, err := arpEntry(ip)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func (d ARPDiscovery) Name() string {
+	return "arp"
+}
+
+// Reports whether the neighbour table has a complete entry for ip
+func arpEntry(ip string) (bool, error) {
+	file, err := os.Open(arpTablePath)
+	if err != nil {
+		return false, fmt.Errorf("ARP table not available: %w", err)
+	}
+	defer file.Close()
+
+	// IP address, HW type, Flags, HW address, Mask, Device
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != ip {
+			continue
+		}
+
+		// 0x2 marks a resolved entry, 0x0 one that never answered
+		if fields[2] != "0x0" && fields[3] != "00:00:00:00:00:00" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// Runs the probes in turn until one finds the host up, returning its name.
+// An empty name means the host looks down; the error explains any probes
+// that couldn't run at all.
+func discoverHost(ctx context.Context, dialer Dialer, host string, timeout time.Duration, probes []DiscoveryProbe) (string, error) {
+	if len(probes) == 0 {
+		probes = defaultDiscovery
+	}
+
+	var errs []error
+	for _, probe := range probes {
+		alive, err := probe.Alive(ctx, dialer, host, timeout)
+		if alive {
+			return probe.Name(), nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", probe.Name(), err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// Builds discovery probes from a list like "icmp,tcp" and the ports TCP
+// discovery should try, both optional
+func parseDiscovery(methods, ports string) ([]DiscoveryProbe, error) {
+	tcpPorts := defaultDiscoveryPorts
+	if ports != "" {
+		targets, err := parsePortSpec(ports)
+		if err != nil {
+			return nil, fmt.Errorf("invalid discovery ports: %w", err)
+		}
+		tcpPorts = []int{}
+		for _, target := range targets {
+			if target.Proto == ProtoTCP {
+				tcpPorts = append(tcpPorts, target.Port)
+			}
+		}
+		if len(tcpPorts) == 0 {
+			return nil, fmt.Errorf("discovery ports must include TCP ports")
+		}
+	}
+
+	if methods == "" {
+		methods = "tcp"
+	}
+
+	probes := []DiscoveryProbe{}
+	for _, method := range strings.Split(methods, ",") {
+		switch strings.ToLower(strings.TrimSpace(method)) {
+		case "tcp":
+			probes = append(probes, TCPDiscovery{Ports: tcpPorts})
+		case "icmp", "ping":
+			probes = append(probes, ICMPDiscovery{})
+		case "arp":
+			probes = append(probes, ARPDiscovery{})
+		default:
+			return nil, fmt.Errorf("unknown discovery method %q (use tcp, icmp, arp or none)", method)
+		}
+	}
+
+	return probes, nil
+}
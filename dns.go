@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a reverse lookup may hold up a host's result
+const reverseLookupTimeout = 2 * time.Second
+
+// Resolves each name or address once per scan and remembers the answer,
+// so thousands of probes to one host don't mean thousands of lookups
+type dnsCache struct {
+	resolver  *net.Resolver
+	passNames bool // Hand hostnames on unresolved, for proxies that resolve them
+
+	mu      sync.Mutex
+	forward map[string]*dnsLookup // Name to addresses
+	reverse map[string]*dnsLookup // Address to PTR names
+}
+
+// A lookup that's finished or still in progress
+type dnsLookup struct {
+	done    chan struct{}
+	answers []string
+	err     error
+}
+
+// Creates a cache that asks server ("10.0.0.53" or "10.0.0.53:5353"),
+// or the system resolver when server is empty
+func newDNSCache(server string) *dnsCache {
+	c := &dnsCache{
+		resolver: net.DefaultResolver,
+		forward:  make(map[string]*dnsLookup),
+		reverse:  make(map[string]*dnsLookup),
+	}
+
+	if server != "" {
+		server = dnsServerAddress(server)
+		c.resolver = &net.Resolver{
+			PreferGo: true, // The cgo resolver ignores Dial
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return c
+}
+
+// Adds the default DNS port when the server doesn't name one
+func dnsServerAddress(server string) string {
+	server = strings.TrimSpace(server)
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(normalizeHost(server), "53")
+}
+
+// Runs fn once per key, later callers wait for and share the first answer
+func (c *dnsCache) lookup(ctx context.Context, table map[string]*dnsLookup, key string, fn func(context.Context) ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	l, exists := table[key]
+	if !exists {
+		l = &dnsLookup{done: make(chan struct{})}
+		table[key] = l
+	}
+	c.mu.Unlock()
+
+	if !exists {
+		l.answers, l.err = fn(ctx)
+
+		// A cancelled lookup says nothing about the name, let the next caller retry
+		if ctx.Err() != nil {
+			c.mu.Lock()
+			delete(table, key)
+			c.mu.Unlock()
+		}
+		close(l.done)
+	}
+
+	select {
+	case <-l.done:
+		return l.answers, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Every address host resolves to, IPv4 first. IP literals come back unchanged.
+func (c *dnsCache) LookupAddrs(ctx context.Context, host string) ([]string, error) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{host}, nil
+	}
+
+	return c.lookup(ctx, c.forward, strings.ToLower(host), func(ctx context.Context) ([]string, error) {
+		return lookupAllAddresses(ctx, c.resolver, host)
+	})
+}
+
+// Addresses host already resolved to, without starting a lookup. Nil when
+// it was never resolved, still is being or the lookup failed.
+func (c *dnsCache) CachedAddrs(host string) []string {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{host}
+	}
+
+	c.mu.Lock()
+	l, exists := c.forward[strings.ToLower(host)]
+	c.mu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	select {
+	case <-l.done:
+		return l.answers
+	default:
+		return nil
+	}
+}
+
+// PTR names for an address, without the trailing dot. Failures just mean no names.
+func (c *dnsCache) LookupNames(ctx context.Context, address string) []string {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return nil
+	}
+
+	names, _ := c.lookup(ctx, c.reverse, addr.String(), func(ctx context.Context) ([]string, error) {
+		// Don't let a silent DNS server stall the scan
+		ctx, cancel := context.WithTimeout(ctx, reverseLookupTimeout)
+		defer cancel()
+
+		names, err := c.resolver.LookupAddr(ctx, addr.String())
+		for i, name := range names {
+			names[i] = strings.TrimSuffix(name, ".")
+		}
+		return names, err
+	})
+	return names
+}
+
+// Resolves host into scan targets: every address when allAddresses is set,
+// otherwise the first one
+func (c *dnsCache) hostEntries(ctx context.Context, host string, allAddresses bool) ([]hostEntry, error) {
+	if c.passNames && addressFamily(host) == "" {
+		return []hostEntry{{name: host, address: host}}, nil
+	}
+
+	addresses, err := c.LookupAddrs(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("resolving %s: no addresses found", host)
+	}
+
+	if !allAddresses {
+		addresses = addresses[:1]
+	}
+
+	entries := make([]hostEntry, 0, len(addresses))
+	for _, address := range addresses {
+		entries = append(entries, hostEntry{name: host, address: address})
+	}
+	return entries, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDNSCacheCachedAddrs(t *testing.T) {
+	c := newDNSCache("")
+	if got := c.CachedAddrs("10.0.0.1"); !slices.Equal(got, []string{"10.0.0.1"}) {
+		t.Errorf("CachedAddrs of an IP = %v, want it unchanged", got)
+	}
+
+	// Never resolved: nothing, and no lookup started
+	if got := c.CachedAddrs("db.example"); got != nil {
+		t.Errorf("CachedAddrs of an unresolved name = %v, want nil", got)
+	}
+	if len(c.forward) != 0 {
+		t.Errorf("CachedAddrs started %d lookups", len(c.forward))
+	}
+
+	// Still resolving: nothing, without waiting for it
+	c.forward["slow.example"] = &dnsLookup{done: make(chan struct{})}
+	if got := c.CachedAddrs("slow.example"); got != nil {
+		t.Errorf("CachedAddrs of a lookup in progress = %v, want nil", got)
+	}
+
+	done := &dnsLookup{done: make(chan struct{}), answers: []string{"10.0.0.5", "2001:db8::5"}}
+	close(done.done)
+	c.forward["db.example"] = done
+	if got := c.CachedAddrs("DB.example"); !slices.Equal(got, done.answers) {
+		t.Errorf("CachedAddrs of a resolved name = %v, want %v", got, done.answers)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// Open file limits are a Unix thing
+func openFileLimit() (uint64, error) {
+	return 0, errors.New("open file limit not available on this system")
+}
+
+// Nothing to raise outside Unix
+func raiseOpenFileLimit() (uint64, error) {
+	return 0, errors.New("open file limit not available on this system")
+}
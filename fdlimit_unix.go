@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Soft limit on open files for this process
+func openFileLimit() (uint64, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	return uint64(limit.Cur), nil
+}
+
+// Raises the soft limit on open files as close to the hard limit as the
+// system allows and returns the new soft limit
+func raiseOpenFileLimit() (uint64, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+
+	// Some systems refuse an unlimited or very high soft limit, so keep
+	// halving the distance to the hard limit until one sticks
+	current := limit.Cur
+	want := limit.Max
+	var err error
+	for want > current {
+		limit.Cur = want
+		if err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+			return uint64(want), nil
+		}
+		want = current + (want-current)/2
+	}
+	return uint64(current), err
+}
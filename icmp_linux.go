@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ICMP message types for echo request and reply
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// Sends one ICMP echo request and waits for the reply. Uses Linux ping
+// sockets (SOCK_DGRAM, IPPROTO_ICMP) so no root is needed, as long as the
+// user's group is inside net.ipv4.ping_group_range.
+func pingHost(ctx context.Context, dialer Dialer, host string, timeout time.Duration) (bool, error) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false, fmt.Errorf("ICMP discovery needs an IP address, got %q", host)
+	}
+	addr = addr.Unmap()
+	source, local := systemSource(dialer)
+	if !local {
+		return false, fmt.Errorf("ICMP discovery needs the local network, not a proxy or custom dialer")
+	}
+
+	family, proto, request, reply := syscall.AF_INET, syscall.IPPROTO_ICMP, icmpv4EchoRequest, icmpv4EchoReply
+	if addr.Is6() {
+		family, proto, request, reply = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, icmpv6EchoRequest, icmpv6EchoReply
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return false, fmt.Errorf("opening ping socket (check net.ipv4.ping_group_range): %w", err)
+	}
+
+	// Leave from the chosen source address
+	if source != nil {
+		if err := bindPingSocket(fd, source, addr); err != nil {
+			syscall.Close(fd)
+			return false, err
+		}
+	}
+	file := os.NewFile(uintptr(fd), "ping")
+	conn, err := net.FilePacketConn(file)
+	file.Close()
+	if err != nil {
+		return false, fmt.Errorf("opening ping socket: %w", err)
+	}
+	defer conn.Close()
+
+	// Stop waiting at the timeout or as soon as the scan is cancelled
+	conn.SetDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	// The kernel fills in our identifier, the sequence number tells replies apart
+	seq := uint16(time.Now().UnixNano())
+	message := []byte{byte(request), 0, 0, 0, 0, 0, byte(seq >> 8), byte(seq), 'p', 'i', 'n', 'g'}
+	if addr.Is4() {
+		sum := icmpChecksum(message)
+		message[2], message[3] = byte(sum>>8), byte(sum)
+	}
+
+	target := &net.UDPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}
+	if _, err := conn.WriteTo(message, target); err != nil {
+		return false, nil
+	}
+
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			// Silence until the deadline means no answer
+			return false, nil
+		}
+
+		source, ok := from.(*net.UDPAddr)
+		if !ok || !source.IP.Equal(target.IP) || n < 8 {
+			continue
+		}
+		if buffer[0] == byte(reply) && buffer[6] == byte(seq>>8) && buffer[7] == byte(seq) {
+			return true, nil
+		}
+	}
+}
+
+// Binds a ping socket to the source address for the target's family
+func bindPingSocket(fd int, source *sourceBinding, target netip.Addr) error {
+	local, err := source.localAddr(target.String())
+	if err != nil || !local.IsValid() {
+		return err
+	}
+
+	var sa syscall.Sockaddr
+	if local.Is4() {
+		sa = &syscall.SockaddrInet4{Addr: local.As4()}
+	} else {
+		sa = &syscall.SockaddrInet6{Addr: local.As16()}
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return fmt.Errorf("binding ping socket to %s: %w", local, err)
+	}
+	return nil
+}
+
+// Internet checksum over an ICMP message
+func icmpChecksum(message []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(message); i += 2 {
+		sum += uint32(message[i])<<8 | uint32(message[i+1])
+	}
+	if len(message)%2 == 1 {
+		sum += uint32(message[len(message)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Unprivileged ping sockets are Linux only
+func pingHost(ctx context.Context, dialer Dialer, host string, timeout time.Duration) (bool, error) {
+	return false, errors.New("ICMP discovery needs Linux ping sockets")
+}
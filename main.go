@@ -1,7 +1,15 @@
-package main
-
-// Starting point of our app
-func main() {
-	// Launches the interactive interface
-	runInteractiveMode()
-}
+package main
+
+import "os"
+
+// Starting point of our app
+func main() {
+	// Launches the interactive interface when there's nothing to run
+	if len(os.Args) < 2 {
+		runInteractiveMode()
+		return
+	}
+
+	// Otherwise runs one command, for scripts and cron jobs
+	os.Exit(runCommandLine(os.Args[1:]))
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Produces target hosts one at a time, false once there are no more
+type HostSource func() (string, bool)
+
+// Walks a fixed list of hosts
+func hostList(hosts []string) HostSource {
+	i := 0
+	return func() (string, bool) {
+		if i >= len(hosts) {
+			return "", false
+		}
+		i++
+		return hosts[i-1], true
+	}
+}
+
+// Scans many hosts through one shared worker pool and reports each host
+// as soon as all of its ports are done
+type MultiScanner struct {
+	scanner *Scanner
+	hosts   HostSource
+	err     error
+
+	// Hosts currently being scanned
+	mu       sync.Mutex
+	inFlight map[string]*hostScan
+}
+
+// Bookkeeping for one in-flight host
+type hostScan struct {
+	name      string // As given by the source
+	host      string // Address being dialled
+	started   time.Time
+	remaining int
+	ports     []PortInfo
+	stats     *statsRecorder
+	next      func() (uint64, bool) // Order to feed its ports in
+}
+
+// Creates a multi-host scanner, options are the same as for NewScanner
+func NewMultiScanner(hosts HostSource, options ...ScannerOption) *MultiScanner {
+	scanner := NewScanner(options...)
+
+	// Need room for at least one host
+	if scanner.maxHosts < 1 {
+		scanner.maxHosts = 1
+	}
+
+	return &MultiScanner{
+		scanner:  scanner,
+		hosts:    hosts,
+		inFlight: make(map[string]*hostScan),
+	}
+}
+
+// Streams one ScanResult per host as each finishes, the channel closes when
+// every host is done. Check Err afterwards to see if the scan was cut short.
+// Like Scanner.Stream, read until it closes or cancel the scan's context.
+func (m *MultiScanner) Results() <-chan ScanResult {
+	out := make(chan ScanResult)
+
+	go func() {
+		defer close(out)
+		m.err = m.run(out)
+	}()
+
+	return out
+}
+
+// Error from the last scan, valid once the results channel is closed
+func (m *MultiScanner) Err() error {
+	return m.err
+}
+
+// Progress across all hosts admitted so far
+func (m *MultiScanner) Progress() ScanProgress {
+	return m.scanner.Progress()
+}
+
+// Statistics across every host, per-host ones come with each ScanResult
+func (m *MultiScanner) Stats() ScanStats {
+	return m.scanner.Stats()
+}
+
+// Grabs a banner while respecting the rate and host limits
+func (m *MultiScanner) Banner(host string, port int) (string, error) {
+	return m.scanner.Banner(host, port)
+}
+
+// Runs discovery, the feeder and the shared worker pool until every host is done
+func (m *MultiScanner) run(out chan<- ScanResult) error {
+	s := m.scanner
+	if err := s.preflight(); err != nil {
+		return err
+	}
+	s.limitConcurrency()
+
+	// Total grows as hosts are admitted
+	s.resetProgress(0)
+	stopReporting := s.startReporting()
+	stopJournal := s.journal.start()
+
+	// Setup channels for work distribution
+	ready := make(chan hostEntry)            // Live hosts waiting for a slot
+	slots := make(chan struct{}, s.maxHosts) // Hosts allowed in flight
+	work := make(chan probeJob, 1000)        // Work queue
+	results := make(chan PortResult, 1000)   // Results collector
+
+	// Hands a finished host to the caller, journaling the ones scanned in this run
+	emit := func(result ScanResult) {
+		if result.Err == nil && !result.Resumed {
+			s.journal.finishHost(result)
+		}
+		select {
+		case out <- result:
+		case <-s.ctx.Done():
+		}
+	}
+
+	s.startWorkers(work, results)
+	go m.discover(ready, emit)
+	go m.feed(ready, slots, work, emit)
+
+	// Count down each host's ports as results arrive
+	for result := range results {
+		if finished := m.record(result); finished != nil {
+			emit(m.hostResult(finished))
+			s.forgetHost(finished.host)
+			<-slots // Make room for the next host
+		}
+	}
+
+	stopReporting()
+	return s.finish(stopJournal)
+}
+
+// Pulls hosts from the source, passing on the ones that pass the liveness check
+func (m *MultiScanner) discover(ready chan<- hostEntry, emit func(ScanResult)) {
+	defer close(ready)
+	s := m.scanner
+
+	// The source isn't safe for concurrent use
+	var sourceMutex sync.Mutex
+	next := func() (string, bool) {
+		sourceMutex.Lock()
+		defer sourceMutex.Unlock()
+		return m.hosts()
+	}
+
+	// Check several hosts at once so slow, dead hosts don't stall the pool
+	checkers := 1
+	if s.aliveTimeout > 0 {
+		checkers = s.maxHosts
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < checkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				host, ok := next()
+				if !ok || s.ctx.Err() != nil {
+					return
+				}
+
+				// Resolve once, each A/AAAA record can become a target of its own
+				entries, err := s.dns.hostEntries(s.ctx, normalizeHost(host), s.allAddresses)
+				if err != nil {
+					if s.ctx.Err() != nil {
+						return
+					}
+					emit(ScanResult{
+						Host:      host,
+						Err:       &ScanError{Host: host, Message: "could not resolve host", Kind: ErrDNSFailure, Err: err},
+						Timestamp: time.Now(),
+					})
+					continue
+				}
+
+				for _, entry := range entries {
+					// Finished before the scan was resumed
+					if saved, ok := s.journal.resumedResult(entry.address); ok {
+						emit(saved)
+						continue
+					}
+
+					// Report dead hosts straight away
+					if s.aliveTimeout > 0 && !s.isHostAlive(entry.address) {
+						emit(ScanResult{
+							Host:      entry.name,
+							Address:   entry.address,
+							Family:    addressFamily(entry.address),
+							Down:      true,
+							Timestamp: time.Now(),
+						})
+						continue
+					}
+
+					select {
+					case ready <- entry:
+						// Queued for scanning
+					case <-s.ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Admits hosts up to the parallelism limit and interleaves their ports into the work queue
+func (m *MultiScanner) feed(ready <-chan hostEntry, slots chan struct{}, work chan<- probeJob, emit func(ScanResult)) {
+	defer close(work)
+	s := m.scanner
+
+	active := []*hostScan{} // Hosts with ports left to send
+	admitted := 0
+	turn := 0
+	readyOpen := true
+	rng := rand.New(rand.NewSource(s.seed))
+
+	// Starts tracking a new host
+	admit := func(entry hostEntry) {
+		host := entry.address
+		h := &hostScan{
+			name:    entry.name,
+			host:    host,
+			started: time.Now(),
+			ports:   s.journal.resumedOpen(host), // Found before the scan was resumed
+			stats:   newStatsRecorder(),
+		}
+		for _, target := range s.ports {
+			if !s.journal.wasProbed(host, target) {
+				h.remaining++
+			}
+		}
+
+		// Each host gets its own shuffle when randomizing
+		if s.randomize {
+			h.next = newPermutation(uint64(len(s.ports)), s.seed+int64(admitted)).Next
+		} else {
+			h.next = sequence(uint64(len(s.ports)))
+		}
+		admitted++
+
+		// Duplicate hosts or empty port lists finish immediately
+		m.mu.Lock()
+		_, duplicate := m.inFlight[host]
+		if !duplicate && h.remaining > 0 {
+			m.inFlight[host] = h
+		}
+		m.mu.Unlock()
+
+		if duplicate || h.remaining == 0 {
+			if !duplicate {
+				emit(m.hostResult(h))
+				s.forgetHost(host)
+			}
+			<-slots
+			return
+		}
+
+		// Look up the IP target's name while its ports are scanned
+		if s.reverseDNS && addressFamily(entry.name) != "" {
+			go s.dns.LookupNames(s.ctx, host)
+		}
+
+		s.total.Add(int64(h.remaining))
+		active = append(active, h)
+	}
+
+	for readyOpen || len(active) > 0 {
+		if readyOpen && len(active) == 0 {
+			// Nothing to feed, wait for both a free slot and a host
+			select {
+			case slots <- struct{}{}:
+			case <-s.ctx.Done():
+				return
+			}
+			select {
+			case entry, ok := <-ready:
+				if !ok {
+					readyOpen = false
+					<-slots
+					continue
+				}
+				admit(entry)
+			case <-s.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		// Take on another host if there's room and one is waiting
+		if readyOpen {
+			select {
+			case slots <- struct{}{}:
+				select {
+				case entry, ok := <-ready:
+					if !ok {
+						readyOpen = false
+						<-slots
+					} else {
+						admit(entry)
+					}
+				default:
+					// Nobody waiting yet
+					<-slots
+				}
+			default:
+				// All slots busy
+			}
+		}
+		if len(active) == 0 {
+			continue
+		}
+
+		// Pick which host gets the next probe, taking turns unless shuffling
+		turn++
+		i := turn % len(active)
+		if s.randomize {
+			i = rng.Intn(len(active))
+		}
+		h := active[i]
+
+		index, ok := h.next()
+		if !ok {
+			// All of this host's ports are queued
+			active = append(active[:i], active[i+1:]...)
+			continue
+		}
+		if s.journal.wasProbed(h.host, s.ports[index]) {
+			// Done before the scan was resumed
+			continue
+		}
+
+		select {
+		case work <- probeJob{host: h.name, address: h.host, target: s.ports[index]}:
+			// Sent for checking
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Records one port result, returning the host if that was its last port
+func (m *MultiScanner) record(result PortResult) *hostScan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, exists := m.inFlight[result.Address]
+	if !exists {
+		return nil
+	}
+
+	h.stats.add(result)
+	if result.Status == StatusOpen {
+		h.ports = append(h.ports, PortInfo{
+			Port:    result.Port,
+			Proto:   result.Proto,
+			Service: identifyService(result.Port, result.Banner),
+			Banner:  result.Banner,
+		})
+	}
+
+	h.remaining--
+	if h.remaining > 0 {
+		return nil
+	}
+
+	delete(m.inFlight, result.Address)
+	return h
+}
+
+// Builds the final result for a finished host
+func (m *MultiScanner) hostResult(h *hostScan) ScanResult {
+	// TCP before UDP on the same port, so the order is the same every run
+	sort.Slice(h.ports, func(i, j int) bool {
+		if h.ports[i].Port != h.ports[j].Port {
+			return h.ports[i].Port < h.ports[j].Port
+		}
+		return h.ports[i].Proto < h.ports[j].Proto
+	})
+
+	// Only what's cached, the host is done so nothing new gets resolved.
+	// Names left to the proxy or dialer never are.
+	s := m.scanner
+	addresses := s.dns.CachedAddrs(h.name)
+	var names []string
+	if addressFamily(h.name) != "" {
+		names = s.ReverseNames(h.host)
+	}
+
+	duration := time.Since(h.started)
+	stats := h.stats.snapshot(duration)
+
+	// Every probe failing the same way is a problem with the host, or with us
+	var err error
+	if failure := stats.Failure(); failure != nil && len(h.ports) == 0 {
+		failure.Host = h.name
+		err = failure
+	}
+
+	return ScanResult{
+		Host:      h.name,
+		Address:   h.host,
+		Family:    addressFamily(h.host),
+		Addresses: addresses,
+		Names:     names,
+		Ports:     h.ports,
+		Timestamp: time.Now(),
+		Duration:  duration,
+		RTT:       m.scanner.RTTStats(h.host),
+		Stats:     stats,
+		Err:       err,
+	}
+}
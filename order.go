@@ -0,0 +1,85 @@
+package main
+
+import "math/bits"
+
+// Walks the numbers 0..n-1 in a seeded pseudo-random order without storing them.
+// A small Feistel network shuffles a power-of-two domain and values >= n are skipped.
+type permutation struct {
+	n        uint64
+	counter  uint64
+	domain   uint64
+	halfBits uint
+	halfMask uint64
+	keys     [4]uint64
+}
+
+// Creates a permutation of n items, the same seed always gives the same order
+func newPermutation(n uint64, seed int64) *permutation {
+	// Split the bits needed for n evenly across both Feistel halves
+	totalBits := uint(bits.Len64(n))
+	if totalBits < 2 {
+		totalBits = 2
+	}
+	halfBits := (totalBits + 1) / 2
+
+	p := &permutation{
+		n:        n,
+		domain:   1 << (2 * halfBits),
+		halfBits: halfBits,
+		halfMask: 1<<halfBits - 1,
+	}
+
+	// Derive round keys from the seed
+	state := uint64(seed)
+	for i := range p.keys {
+		state = splitmix64(state)
+		p.keys[i] = state
+	}
+
+	return p
+}
+
+// Returns the next index, false once every index has been handed out
+func (p *permutation) Next() (uint64, bool) {
+	// Cycle-walk past values outside 0..n-1
+	for p.counter < p.domain {
+		v := p.shuffle(p.counter)
+		p.counter++
+		if v < p.n {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// Keyed bijection on the domain
+func (p *permutation) shuffle(v uint64) uint64 {
+	left := v >> p.halfBits
+	right := v & p.halfMask
+
+	for _, key := range p.keys {
+		left, right = right, left^(splitmix64(right^key)&p.halfMask)
+	}
+
+	return left<<p.halfBits | right
+}
+
+// Cheap 64-bit mixer (from SplitMix64)
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Walks the numbers 0..n-1 in plain ascending order
+func sequence(n uint64) func() (uint64, bool) {
+	var i uint64
+	return func() (uint64, bool) {
+		if i >= n {
+			return 0, false
+		}
+		i++
+		return i - 1, true
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Every index the permutation hands out, in order
+func permuted(n uint64, seed int64) []uint64 {
+	p := newPermutation(n, seed)
+	order := []uint64{}
+	for v, ok := p.Next(); ok; v, ok = p.Next() {
+		order = append(order, v)
+	}
+	return order
+}
+
+func TestPermutationBijection(t *testing.T) {
+	for _, n := range []uint64{0, 1, 2, 3, 5, 7, 100, 1000, 4096, 5000} {
+		for _, seed := range []int64{0, 1, -7, 1 << 40} {
+			order := permuted(n, seed)
+			if uint64(len(order)) != n {
+				t.Errorf("n=%d seed=%d: %d indexes, want %d", n, seed, len(order), n)
+				continue
+			}
+
+			seen := make([]bool, n)
+			for _, v := range order {
+				if v >= n || seen[v] {
+					t.Errorf("n=%d seed=%d: index %d out of range or repeated", n, seed, v)
+					break
+				}
+				seen[v] = true
+			}
+		}
+	}
+}
+
+func TestPermutationSeed(t *testing.T) {
+	if !slices.Equal(permuted(1000, 42), permuted(1000, 42)) {
+		t.Error("the same seed gave two different orders")
+	}
+	if slices.Equal(permuted(1000, 42), permuted(1000, 43)) {
+		t.Error("different seeds gave the same order")
+	}
+	if slices.IsSorted(permuted(1000, 42)) {
+		t.Error("the permutation kept ascending order")
+	}
+}
+
+func TestSequence(t *testing.T) {
+	next := sequence(3)
+	order := []uint64{}
+	for v, ok := next(); ok; v, ok = next() {
+		order = append(order, v)
+	}
+	if !slices.Equal(order, []uint64{0, 1, 2}) {
+		t.Errorf("sequence(3) = %v, want [0 1 2]", order)
+	}
+}
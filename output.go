@@ -1,175 +1,437 @@
-package main
-
-import (
-	"encoding/csv"
-	"fmt"
-	"os"
-	"strconv"
-	"time"
-)
-
-// Exports scan results to CSV file
-func saveToCSV(filename string, results map[string][]int) error {
-	// Create output file
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("error creating CSV file: %w", err)
-	}
-	defer file.Close()
-
-	// Setup CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Add header row
-	header := []string{"Host", "Port", "Service", "Timestamp"}
-	err = writer.Write(header)
-	if err != nil {
-		return fmt.Errorf("error writing CSV header: %w", err)
-	}
-
-	// Write each host/port combination
-	timestamp := time.Now().Format(time.RFC3339)
-	for host, ports := range results {
-		for _, port := range ports {
-			service := getServiceName(port)
-			row := []string{
-				host,
-				strconv.Itoa(port),
-				service,
-				timestamp,
-			}
-			err = writer.Write(row)
-			if err != nil {
-				return fmt.Errorf("error writing CSV row: %w", err)
-			}
-		}
-	}
-
-	return nil
-}
-
-// Formats time duration to be human-readable
-func formatDuration(d time.Duration) string {
-	// Round to seconds for display
-	d = d.Round(time.Second)
-
-	h := d / time.Hour
-	d -= h * time.Hour
-
-	m := d / time.Minute
-	d -= m * time.Minute
-
-	s := d / time.Second
-
-	if h > 0 {
-		return fmt.Sprintf("%dh %dm %ds", h, m, s)
-	}
-	if m > 0 {
-		return fmt.Sprintf("%dm %ds", m, s)
-	}
-	return fmt.Sprintf("%ds", s)
-}
-
-// Creates a one-line summary of scan results
-func formatResultSummary(host string, openPorts []int) string {
-	// No open ports case
-	if len(openPorts) == 0 {
-		return fmt.Sprintf("No open ports found on %s", host)
-	}
-
-	// Show all ports if 10 or fewer
-	if len(openPorts) <= 10 {
-		summary := fmt.Sprintf("%d open ports on %s: ", len(openPorts), host)
-		for i, port := range openPorts {
-			service := getServiceName(port)
-			if i > 0 {
-				summary += ", "
-			}
-			summary += fmt.Sprintf("%d (%s)", port, service)
-		}
-		return summary
-	}
-
-	// Truncate if too many ports
-	summary := fmt.Sprintf("%d open ports on %s including: ", len(openPorts), host)
-	for i := 0; i < 5; i++ {
-		service := getServiceName(openPorts[i])
-		if i > 0 {
-			summary += ", "
-		}
-		summary += fmt.Sprintf("%d (%s)", openPorts[i], service)
-	}
-	summary += ", ..."
-	return summary
-}
-
-// Builds a complete text report of scan findings
-func generateScanReport(results map[string][]int, startTime time.Time) string {
-	duration := time.Since(startTime)
-
-	// Get stats
-	hostCount := len(results)
-	portCount := 0
-	for _, ports := range results {
-		portCount += len(ports)
-	}
-
-	// Header section
-	report := fmt.Sprintf("PORT SCANNER REPORT\n")
-	report += fmt.Sprintf("=================\n\n")
-	report += fmt.Sprintf("Scan completed at: %s\n", time.Now().Format(time.RFC1123))
-	report += fmt.Sprintf("Duration: %s\n", formatDuration(duration))
-	report += fmt.Sprintf("Hosts scanned: %d\n", hostCount)
-	report += fmt.Sprintf("Open ports found: %d\n\n", portCount)
-
-	// Results section
-	report += fmt.Sprintf("DETAILED RESULTS\n")
-	report += fmt.Sprintf("----------------\n\n")
-
-	for host, ports := range results {
-		report += fmt.Sprintf("Host: %s\n", host)
-		report += fmt.Sprintf("Open ports: %d\n", len(ports))
-
-		// Try to identify OS
-		if len(ports) > 0 {
-			report += fmt.Sprintf("OS Detection: %s\n", guessOS(ports))
-		}
-
-		// List ports and services
-		if len(ports) > 0 {
-			report += "PORT\tSERVICE\n"
-			report += "----\t-------\n"
-			for _, port := range ports {
-				service := getServiceName(port)
-				report += fmt.Sprintf("%d\t%s\n", port, service)
-			}
-		} else {
-			report += "No open ports found\n"
-		}
-
-		report += "\n"
-	}
-
-	// Footer
-	report += "Scan completed successfully\n"
-	return report
-}
-
-// Writes report to text file
-func saveReportToFile(filename string, report string) error {
-	// Create file
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("error creating report file: %w", err)
-	}
-	defer file.Close()
-
-	// Save report content
-	_, err = file.WriteString(report)
-	if err != nil {
-		return fmt.Errorf("error writing report: %w", err)
-	}
-
-	return nil
-}
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exports scan results to CSV file
+func saveToCSV(filename string, results map[string][]int) error {
+	// Create output file
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating CSV file: %w", err)
+	}
+	defer file.Close()
+
+	// Setup CSV writer
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	// Add header row
+	header := []string{"Host", "Port", "Service", "Timestamp"}
+	err = writer.Write(header)
+	if err != nil {
+		return fmt.Errorf("error writing CSV header: %w", err)
+	}
+
+	// Write each host/port combination
+	timestamp := time.Now().Format(time.RFC3339)
+	for host, ports := range results {
+		for _, port := range ports {
+			service := getServiceName(port)
+			row := []string{
+				host,
+				strconv.Itoa(port),
+				service,
+				timestamp,
+			}
+			err = writer.Write(row)
+			if err != nil {
+				return fmt.Errorf("error writing CSV row: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Formats time duration to be human-readable
+func formatDuration(d time.Duration) string {
+	// Round to seconds for display
+	d = d.Round(time.Second)
+
+	h := d / time.Hour
+	d -= h * time.Hour
+
+	m := d / time.Minute
+	d -= m * time.Minute
+
+	s := d / time.Second
+
+	if h > 0 {
+		return fmt.Sprintf("%dh %dm %ds", h, m, s)
+	}
+	if m > 0 {
+		return fmt.Sprintf("%dm %ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+// Names a host result, adding the address and family when they add information
+func formatHostLabel(result ScanResult) string {
+	if result.Address == "" || result.Address == result.Host {
+		return result.Host
+	}
+	return fmt.Sprintf("%s [%s, %s]", result.Host, result.Address, result.Family)
+}
+
+// Formats measured round-trip times on one line
+func formatRTTStats(stats RTTStats) string {
+	if stats.Samples == 0 {
+		return fmt.Sprintf("RTT: no replies measured (timeout %s)", stats.Timeout)
+	}
+
+	// Sub-millisecond precision is plenty
+	round := func(d time.Duration) time.Duration {
+		return d.Round(100 * time.Microsecond)
+	}
+
+	return fmt.Sprintf("RTT: avg %s ±%s, min %s, max %s over %d replies (timeout %s)",
+		round(stats.SRTT), round(stats.RTTVar), round(stats.Min), round(stats.Max),
+		stats.Samples, round(stats.Timeout))
+}
+
+// Formats scan statistics as a few lines, the connect time histogram last
+func formatScanStats(stats ScanStats) []string {
+	// Sub-millisecond precision is plenty
+	round := func(d time.Duration) time.Duration {
+		return d.Round(100 * time.Microsecond)
+	}
+
+	// Outcomes in PortStatus order
+	counts := []string{}
+	for status := StatusOpen; status <= StatusOpenFiltered; status++ {
+		if n := stats.Statuses[status]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, strings.ToLower(status.String())))
+		}
+	}
+	line := fmt.Sprintf("Probed %d ports in %s (%.1f probes/sec)",
+		stats.Probed, stats.Elapsed.Round(time.Millisecond), stats.Rate)
+	if len(counts) > 0 {
+		line += ": " + strings.Join(counts, ", ")
+	}
+	if stats.Retries > 0 {
+		line += fmt.Sprintf(", %d retried", stats.Retries)
+	}
+	lines := []string{line}
+
+	// Probes in flight when that wasn't simply the thread count, and why
+	concurrency := ""
+	switch {
+	case stats.AutoThreads:
+		concurrency = fmt.Sprintf("Concurrency: tuned to %d (peak %d) of %d threads",
+			stats.Concurrency, stats.PeakConcurrency, stats.Threads)
+	case stats.Concurrency > 0 && (stats.Concurrency < stats.Threads || stats.Requeued > 0):
+		concurrency = fmt.Sprintf("Concurrency: %d of %d threads", stats.Concurrency, stats.Threads)
+	}
+	if concurrency != "" {
+		if stats.FileLimit > 0 && stats.FileLimit < uint64(stats.Threads+fdReserve) {
+			concurrency += fmt.Sprintf(" (open file limit %d)", stats.FileLimit)
+		}
+		if stats.Requeued > 0 {
+			concurrency += fmt.Sprintf(", %d probes requeued after running out of sockets", stats.Requeued)
+		}
+		lines = append(lines, concurrency)
+	}
+
+	// Most common reason first
+	if len(stats.Errors) > 0 {
+		reasons := make([]string, 0, len(stats.Errors))
+		for reason := range stats.Errors {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			if stats.Errors[reasons[i]] != stats.Errors[reasons[j]] {
+				return stats.Errors[reasons[i]] > stats.Errors[reasons[j]]
+			}
+			return reasons[i] < reasons[j]
+		})
+		for i, reason := range reasons {
+			reasons[i] = fmt.Sprintf("%s %d", reason, stats.Errors[reason])
+		}
+		lines = append(lines, "Not open: "+strings.Join(reasons, ", "))
+	}
+
+	// Problems that need fixing before the numbers above mean much
+	for _, failure := range stats.Failures {
+		lines = append(lines, fmt.Sprintf("Failed: %d probes, %v (first: %v)", failure.Count, failure.Kind, failure.First))
+	}
+
+	latency := stats.Latency
+	if latency.Samples == 0 {
+		return append(lines, "Connect time: no replies measured")
+	}
+	lines = append(lines, fmt.Sprintf("Connect time: min %s, avg %s, p95 %s, max %s over %d replies",
+		round(latency.Min), round(latency.Avg), round(latency.P95), round(latency.Max), latency.Samples))
+
+	// One bar per bucket, scaled to the fullest
+	most := 0
+	for _, bucket := range latency.Buckets {
+		if bucket.Count > most {
+			most = bucket.Count
+		}
+	}
+	for _, bucket := range latency.Buckets {
+		width := (bucket.Count*30 + most - 1) / most
+		lines = append(lines, fmt.Sprintf("  < %-8s %-30s %d", bucket.Below, strings.Repeat("█", width), bucket.Count))
+	}
+
+	return lines
+}
+
+// Creates a one-line summary of scan results
+func formatResultSummary(host string, openPorts []int) string {
+	// No open ports case
+	if len(openPorts) == 0 {
+		return fmt.Sprintf("No open ports found on %s", host)
+	}
+
+	// Show all ports if 10 or fewer
+	if len(openPorts) <= 10 {
+		summary := fmt.Sprintf("%d open ports on %s: ", len(openPorts), host)
+		for i, port := range openPorts {
+			service := getServiceName(port)
+			if i > 0 {
+				summary += ", "
+			}
+			summary += fmt.Sprintf("%d (%s)", port, service)
+		}
+		return summary
+	}
+
+	// Truncate if too many ports
+	summary := fmt.Sprintf("%d open ports on %s including: ", len(openPorts), host)
+	for i := 0; i < 5; i++ {
+		service := getServiceName(openPorts[i])
+		if i > 0 {
+			summary += ", "
+		}
+		summary += fmt.Sprintf("%d (%s)", openPorts[i], service)
+	}
+	summary += ", ..."
+	return summary
+}
+
+// Builds a complete text report of scan findings
+func generateScanReport(results map[string][]int, startTime time.Time) string {
+	duration := time.Since(startTime)
+
+	// Get stats
+	hostCount := len(results)
+	portCount := 0
+	for _, ports := range results {
+		portCount += len(ports)
+	}
+
+	// Header section
+	report := fmt.Sprintf("PORT SCANNER REPORT\n")
+	report += fmt.Sprintf("=================\n\n")
+	report += fmt.Sprintf("Scan completed at: %s\n", time.Now().Format(time.RFC1123))
+	report += fmt.Sprintf("Duration: %s\n", formatDuration(duration))
+	report += fmt.Sprintf("Hosts scanned: %d\n", hostCount)
+	report += fmt.Sprintf("Open ports found: %d\n\n", portCount)
+
+	// Results section
+	report += fmt.Sprintf("DETAILED RESULTS\n")
+	report += fmt.Sprintf("----------------\n\n")
+
+	for host, ports := range results {
+		report += fmt.Sprintf("Host: %s\n", host)
+		report += fmt.Sprintf("Open ports: %d\n", len(ports))
+
+		// Try to identify OS
+		if len(ports) > 0 {
+			report += fmt.Sprintf("OS Detection: %s\n", guessOS(ports))
+		}
+
+		// List ports and services
+		if len(ports) > 0 {
+			report += "PORT\tSERVICE\n"
+			report += "----\t-------\n"
+			for _, port := range ports {
+				service := getServiceName(port)
+				report += fmt.Sprintf("%d\t%s\n", port, service)
+			}
+		} else {
+			report += "No open ports found\n"
+		}
+
+		report += "\n"
+	}
+
+	// Footer
+	report += "Scan completed successfully\n"
+	return report
+}
+
+// Writes report to text file
+func saveReportToFile(filename string, report string) error {
+	// Create file
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating report file: %w", err)
+	}
+	defer file.Close()
+
+	// Save report content
+	_, err = file.WriteString(report)
+	if err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+
+	return nil
+}
+
+// One host in JSON output, also read back by the report command
+type reportHost struct {
+	Host      string
+	Address   string   `json:",omitempty"`
+	Names     []string `json:",omitempty"`
+	Open      []PortInfo
+	Down      bool   `json:",omitempty"`
+	Partial   bool   `json:",omitempty"` // Interrupted, more ports may be open
+	Error     string `json:",omitempty"` // Why the host couldn't be scanned
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+// Results of a scan or range command, the JSON output format
+type scanReport struct {
+	Command  []string
+	Started  time.Time
+	Duration time.Duration
+	Hosts    []reportHost
+}
+
+// Collects finished host results into a report
+func newScanReport(command []string, started time.Time, results []ScanResult) scanReport {
+	report := scanReport{
+		Command:  command,
+		Started:  started,
+		Duration: time.Since(started),
+		Hosts:    []reportHost{},
+	}
+
+	for _, result := range results {
+		host := reportHost{
+			Host:      result.Host,
+			Address:   result.Address,
+			Names:     result.Names,
+			Open:      result.Ports,
+			Down:      result.Down,
+			Timestamp: result.Timestamp,
+			Duration:  result.Duration,
+		}
+		if host.Open == nil {
+			host.Open = []PortInfo{}
+		}
+		if result.Err != nil {
+			host.Error = result.Err.Error()
+		}
+		report.Hosts = append(report.Hosts, host)
+	}
+
+	return report
+}
+
+// Builds a report from a checkpoint, hosts it never finished are marked partial
+func checkpointReport(cp *Checkpoint) scanReport {
+	report := scanReport{
+		Command: cp.Command,
+		Started: cp.Saved,
+		Hosts:   []reportHost{},
+	}
+
+	for _, saved := range cp.Hosts {
+		report.Hosts = append(report.Hosts, reportHost{
+			Host:      saved.Name,
+			Address:   saved.Address,
+			Names:     saved.Names,
+			Open:      append([]PortInfo{}, saved.Open...),
+			Down:      saved.Down,
+			Partial:   !saved.Finished,
+			Timestamp: saved.Timestamp,
+			Duration:  saved.Duration,
+		})
+	}
+
+	return report
+}
+
+// Writes a report as text, json or csv
+func writeScanReport(w io.Writer, format string, report scanReport) error {
+	switch format {
+	case "text":
+		return writeTextReport(w, report)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "csv":
+		return writeCSVReport(w, report)
+	default:
+		return fmt.Errorf("unknown output format %q (use text, json or csv)", format)
+	}
+}
+
+// One line per host, then one per open port
+func writeTextReport(w io.Writer, report scanReport) error {
+	out := bufio.NewWriter(w)
+
+	for _, host := range report.Hosts {
+		label := host.Host
+		if host.Address != "" && host.Address != host.Host {
+			label = fmt.Sprintf("%s [%s]", host.Host, host.Address)
+		}
+
+		switch {
+		case host.Error != "":
+			fmt.Fprintf(out, "%s: error: %s\n", label, host.Error)
+		case host.Down:
+			fmt.Fprintf(out, "%s: down\n", label)
+		case host.Partial:
+			fmt.Fprintf(out, "%s: %d open ports so far (unfinished)\n", label, len(host.Open))
+		default:
+			fmt.Fprintf(out, "%s: %d open ports\n", label, len(host.Open))
+		}
+
+		for _, info := range host.Open {
+			line := fmt.Sprintf("  %-9s %-14s %s", fmt.Sprintf("%d/%s", info.Port, info.Proto), info.Service, info.Banner)
+			fmt.Fprintln(out, strings.TrimRight(line, " "))
+		}
+	}
+
+	return out.Flush()
+}
+
+// One row per open port, like saveToCSV with the address, protocol and banner added
+func writeCSVReport(w io.Writer, report scanReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Host", "Address", "Port", "Protocol", "Service", "Banner", "Timestamp"})
+
+	for _, host := range report.Hosts {
+		for _, info := range host.Open {
+			writer.Write([]string{
+				host.Host,
+				host.Address,
+				strconv.Itoa(info.Port),
+				info.Proto.String(),
+				info.Service,
+				info.Banner,
+				host.Timestamp.Format(time.RFC3339),
+			})
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Shorthand for test tables
+func tcp(port int) PortTarget { return PortTarget{Port: port, Proto: ProtoTCP} }
+func udp(port int) PortTarget { return PortTarget{Port: port, Proto: ProtoUDP} }
+
+func TestParsePortSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []PortTarget
+	}{
+		{"80", []PortTarget{tcp(80)}},
+		{" 443 , 22,80 ", []PortTarget{tcp(22), tcp(80), tcp(443)}},
+		{"20-22", []PortTarget{tcp(20), tcp(21), tcp(22)}},
+		{"22,21-23,22", []PortTarget{tcp(21), tcp(22), tcp(23)}},
+		{"-3", []PortTarget{tcp(1), tcp(2), tcp(3)}},
+		{"65534-", []PortTarget{tcp(65534), tcp(65535)}},
+		{"U:53", []PortTarget{udp(53)}},
+		{"u:53,161", []PortTarget{udp(53), udp(161)}},
+		{"22,U:53,T:80", []PortTarget{tcp(22), tcp(80), udp(53)}},
+		{"U:53,t:53", []PortTarget{tcp(53), udp(53)}},
+		{"top:3", []PortTarget{tcp(23), tcp(80), tcp(443)}},
+		{"U:top:1", []PortTarget{udp(80)}},
+	}
+	for _, tt := range tests {
+		got, err := parsePortSpec(tt.spec)
+		if err != nil {
+			t.Errorf("parsePortSpec(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePortSpec(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParsePortSpecErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"  ",
+		"0",
+		"65536",
+		"http",
+		"100-50",
+		"1-2-3",
+		"22,,80",
+		"U:",
+		"top:0",
+		"top:x",
+	} {
+		if got, err := parsePortSpec(spec); err == nil {
+			t.Errorf("parsePortSpec(%q) = %v, want an error", spec, got)
+		}
+	}
+}
+
+func TestFormatPortSpec(t *testing.T) {
+	tests := []struct {
+		targets []PortTarget
+		want    string
+	}{
+		{nil, ""},
+		{[]PortTarget{tcp(80)}, "80"},
+		{portRange(1, 1024), "1-1024"},
+		{[]PortTarget{tcp(443), tcp(22), tcp(80), tcp(81)}, "22,80-81,443"},
+		{[]PortTarget{udp(161), tcp(22), udp(53)}, "22,U:53,161"},
+		{[]PortTarget{udp(53), udp(54)}, "U:53-54"},
+		{[]PortTarget{tcp(22), tcp(22)}, "22"},
+	}
+	for _, tt := range tests {
+		if got := formatPortSpec(tt.targets); got != tt.want {
+			t.Errorf("formatPortSpec(%v) = %q, want %q", tt.targets, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPortSpecRoundTrip(t *testing.T) {
+	for _, spec := range []string{
+		"1-65535",
+		"22,80,443,8000-8100",
+		"U:53,161",
+		"21-23,U:53,67-69,T:8080",
+		"top:100",
+		"U:top:20,T:top:20",
+	} {
+		targets, err := parsePortSpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted := formatPortSpec(targets)
+		again, err := parsePortSpec(formatted)
+		if err != nil {
+			t.Errorf("%q formatted as %q, which doesn't parse: %v", spec, formatted, err)
+			continue
+		}
+		if !reflect.DeepEqual(again, targets) {
+			t.Errorf("%q formatted as %q, which parses to different ports", spec, formatted)
+		}
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	if got := portRange(5, 7); !reflect.DeepEqual(got, []PortTarget{tcp(5), tcp(6), tcp(7)}) {
+		t.Errorf("portRange(5, 7) = %v", got)
+	}
+	if got := portRange(100, 50); len(got) != 0 {
+		t.Errorf("portRange(100, 50) = %v, want nothing", got)
+	}
+}
+
+func TestProtocolJSON(t *testing.T) {
+	data, err := json.Marshal([]PortTarget{tcp(22), udp(53)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"Port":22,"Proto":"tcp"},{"Port":53,"Proto":"udp"}]`; string(data) != want {
+		t.Errorf("marshalled to %s, want %s", data, want)
+	}
+
+	var targets []PortTarget
+	if err := json.Unmarshal([]byte(`[{"Port":22,"Proto":"TCP"},{"Port":53,"Proto":"udp"}]`), &targets); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []PortTarget{tcp(22), udp(53)}) {
+		t.Errorf("unmarshalled to %v", targets)
+	}
+	if err := json.Unmarshal([]byte(`[{"Port":1,"Proto":"sctp"}]`), &targets); err == nil {
+		t.Error("unmarshalling an unknown protocol succeeded")
+	}
+}
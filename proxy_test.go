@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Listens on a free localhost port until the test ends
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// Runs handle for every connection l accepts
+func serveLocal(l net.Listener, handle func(net.Conn)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// An open port that greets with banner, and a port nothing listens on
+func localPorts(t *testing.T, banner string) (open, closed int) {
+	t.Helper()
+	l := listenLocal(t)
+	go serveLocal(l, func(conn net.Conn) {
+		io.WriteString(conn, banner)
+		io.Copy(io.Discard, conn)
+	})
+
+	gone, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone.Close()
+	return l.Addr().(*net.TCPAddr).Port, gone.Addr().(*net.TCPAddr).Port
+}
+
+// Copies both ways until either side closes
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// Minimal SOCKS5 proxy for IPv4 targets. It connects to the target itself
+// unless fail holds a reply code to send instead.
+func socks5StandIn(t *testing.T, fail byte) string {
+	l := listenLocal(t)
+	go serveLocal(l, func(conn net.Conn) {
+		buf := make([]byte, 255)
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+			return
+		}
+		conn.Write([]byte{0x05, 0x00})
+
+		// Version, CONNECT, reserved, IPv4, address and port
+		if _, err := io.ReadFull(conn, buf[:10]); err != nil || buf[3] != 0x01 {
+			return
+		}
+		target := net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(buf[8:10]))))
+
+		code := fail
+		var upstream net.Conn
+		if code == 0 {
+			var err error
+			if upstream, err = net.Dial("tcp", target); err != nil {
+				code = 0x05
+			}
+		}
+		conn.Write([]byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		if upstream != nil {
+			splice(conn, upstream)
+		}
+	})
+	return "socks5://" + l.Addr().String()
+}
+
+// Minimal HTTP CONNECT proxy, answering status when it can't reach the target
+func connectStandIn(t *testing.T, status int) string {
+	l := listenLocal(t)
+	go serveLocal(l, func(conn net.Conn) {
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || request.Method != http.MethodConnect {
+			return
+		}
+
+		upstream, err := net.Dial("tcp", request.Host)
+		if err != nil {
+			fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		splice(conn, upstream)
+	})
+	return "http://" + l.Addr().String()
+}
+
+// Scans open and closed through proxyURL, returning the results by port
+func scanThroughProxy(t *testing.T, proxyURL string, open, closed int) (map[int]PortResult, *Scanner) {
+	t.Helper()
+	s := NewScanner(
+		WithTarget("127.0.0.1"),
+		WithPorts([]PortTarget{{Port: open, Proto: ProtoTCP}, {Port: closed, Proto: ProtoTCP}}),
+		WithProxy(proxyURL),
+		WithTimeout(2*time.Second),
+		WithReverseDNS(false),
+		WithProgress(false),
+	)
+	results, err := s.ScanDetailed()
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	byPort := make(map[int]PortResult)
+	for _, result := range results {
+		byPort[result.Port] = result
+	}
+	return byPort, s
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	open, closed := localPorts(t, "SSH-2.0-StandIn\r\n")
+
+	results, _ := scanThroughProxy(t, socks5StandIn(t, 0), open, closed)
+	if got := results[open].Status; got != StatusOpen {
+		t.Errorf("open port through SOCKS5 is %v, want open", got)
+	}
+	if got := results[closed].Status; got != StatusClosed {
+		t.Errorf("closed port through SOCKS5 is %v, want closed", got)
+	}
+}
+
+func TestSOCKS5ProxyFailure(t *testing.T) {
+	open, closed := localPorts(t, "")
+
+	// General failure says nothing about the ports
+	results, s := scanThroughProxy(t, socks5StandIn(t, 0x01), open, closed)
+	for _, port := range []int{open, closed} {
+		if result := results[port]; result.Status != StatusError || !errors.Is(result.Err, ErrProxyFailure) {
+			t.Errorf("port %d is %v (%v), want an error of kind ErrProxyFailure", port, result.Status, result.Err)
+		}
+	}
+	if failure := s.Stats().Failure(); failure == nil || !errors.Is(failure, ErrProxyFailure) {
+		t.Errorf("Failure() = %v, want ErrProxyFailure", failure)
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	open, closed := localPorts(t, "SSH-2.0-StandIn\r\n")
+
+	tests := []struct {
+		status int
+		want   PortStatus
+	}{
+		{http.StatusGatewayTimeout, StatusFiltered},
+		{http.StatusBadGateway, StatusError},
+		{http.StatusServiceUnavailable, StatusError},
+	}
+	for _, tt := range tests {
+		results, _ := scanThroughProxy(t, connectStandIn(t, tt.status), open, closed)
+		if got := results[open].Status; got != StatusOpen {
+			t.Errorf("%d proxy: open port is %v, want open", tt.status, got)
+		}
+		if got := results[closed].Status; got != tt.want {
+			t.Errorf("%d proxy: closed port is %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPConnectBanner(t *testing.T) {
+	open, _ := localPorts(t, "SSH-2.0-StandIn\r\n")
+
+	s := NewScanner(WithProxy(connectStandIn(t, http.StatusBadGateway)), WithTimeout(2*time.Second))
+	banner, err := s.Banner("127.0.0.1", open)
+	if err != nil || !strings.HasPrefix(banner, "SSH-2.0-StandIn") {
+		t.Errorf("Banner() = %q, %v, want the target's greeting", banner, err)
+	}
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	_, closed := localPorts(t, "")
+
+	for _, scheme := range []string{"socks5", "http"} {
+		s := NewScanner(
+			WithTarget("127.0.0.1"),
+			WithPortRange(closed, closed),
+			WithProxy(fmt.Sprintf("%s://127.0.0.1:%d", scheme, closed)),
+			WithReverseDNS(false),
+			WithProgress(false),
+		)
+		if _, err := s.ScanDetailed(); !errors.Is(err, ErrProxyFailure) {
+			t.Errorf("%s scan through a dead proxy returned %v, want ErrProxyFailure", scheme, err)
+		}
+	}
+}
+
+func TestProxyUDPRejected(t *testing.T) {
+	proxyURL := socks5StandIn(t, 0)
+
+	s := NewScanner(
+		WithTarget("127.0.0.1"),
+		WithPorts([]PortTarget{{Port: 22, Proto: ProtoTCP}, {Port: 53, Proto: ProtoUDP}}),
+		WithProxy(proxyURL),
+		WithReverseDNS(false),
+		WithProgress(false),
+	)
+	results, err := s.ScanDetailed()
+	if err == nil || !strings.Contains(err.Error(), "UDP") {
+		t.Errorf("scan of a UDP port through a proxy returned %v, want one error about UDP", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("scan probed %d ports before rejecting UDP", len(results))
+	}
+
+	m := NewMultiScanner(hostList([]string{"127.0.0.1"}), WithPorts([]PortTarget{{Port: 53, Proto: ProtoUDP}}),
+		WithProxy(proxyURL), WithReverseDNS(false), WithProgress(false))
+	for result := range m.Results() {
+		t.Errorf("got a result for %s before UDP was rejected", result.Host)
+	}
+	if err := m.Err(); err == nil || !strings.Contains(err.Error(), "UDP") {
+		t.Errorf("MultiScanner through a proxy returned %v, want the UDP error", err)
+	}
+}
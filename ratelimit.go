@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Token bucket shared by every worker to cap probes per second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64 // Bucket size
+	tokens float64
+	last   time.Time
+}
+
+// Creates a limiter allowing perSecond probes, with a small burst allowance
+func newRateLimiter(perSecond int) *rateLimiter {
+	burst := float64(perSecond) / 10
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   float64(perSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Blocks until a token is available or the context is done
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	r.mu.Lock()
+
+	// Top up the bucket for the time that passed
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	// Reserve a token, going negative means waiting our turn
+	r.tokens--
+	wait := time.Duration(0)
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.mu.Unlock()
+
+	return sleepContext(ctx, wait)
+}
+
+// Per-host cap on concurrent connections and spacing between probes
+type hostLimiter struct {
+	maxConns int
+	delay    time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+// Throttling state for a single host
+type hostSlot struct {
+	conns chan struct{} // Semaphore, nil when unlimited
+
+	mu   sync.Mutex
+	next time.Time // Earliest time the next probe may start
+}
+
+// Creates a per-host limiter, zero values mean no limit
+func newHostLimiter(maxConns int, delay time.Duration) *hostLimiter {
+	return &hostLimiter{
+		maxConns: maxConns,
+		delay:    delay,
+		hosts:    make(map[string]*hostSlot),
+	}
+}
+
+// Waits for a free connection slot on host, call release when done
+func (h *hostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	slot := h.slot(host)
+
+	// Respect the concurrent connection cap
+	if slot.conns != nil {
+		select {
+		case slot.conns <- struct{}{}:
+			// Got a slot
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	release := func() {
+		if slot.conns != nil {
+			<-slot.conns
+		}
+	}
+
+	if err := h.space(ctx, slot); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// Waits out the delay between probes to host, for a connection that
+// already holds one of its slots
+func (h *hostLimiter) Pace(ctx context.Context, host string) error {
+	return h.space(ctx, h.slot(host))
+}
+
+// Spaces probes to the same host out
+func (h *hostLimiter) space(ctx context.Context, slot *hostSlot) error {
+	if h.delay <= 0 {
+		return nil
+	}
+
+	slot.mu.Lock()
+	now := time.Now()
+	start := slot.next
+	if start.Before(now) {
+		start = now
+	}
+	slot.next = start.Add(h.delay)
+	slot.mu.Unlock()
+
+	return sleepContext(ctx, start.Sub(now))
+}
+
+// Gets or creates the state for host
+func (h *hostLimiter) slot(host string) *hostSlot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	slot, exists := h.hosts[host]
+	if !exists {
+		slot = &hostSlot{}
+		if h.maxConns > 0 {
+			slot.conns = make(chan struct{}, h.maxConns)
+		}
+		h.hosts[host] = slot
+	}
+
+	return slot
+}
+
+// Drops the state for a host nothing is probing anymore
+func (h *hostLimiter) forget(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hosts, host)
+}
+
+// Sleeps for d unless the context is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
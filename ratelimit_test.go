@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Wraps a dialer to track how many connections are open at once
+type countingDialer struct {
+	Dialer
+	mu         sync.Mutex
+	open, peak int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.open++
+	d.peak = max(d.peak, d.open)
+	d.mu.Unlock()
+
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		d.closed()
+		return nil, err
+	}
+	return &countedConn{Conn: conn, dialer: d}, nil
+}
+
+// Counts a connection as gone
+func (d *countingDialer) closed() {
+	d.mu.Lock()
+	d.open--
+	d.mu.Unlock()
+}
+
+// Connection that tells its dialer when it's closed
+type countedConn struct {
+	net.Conn
+	dialer *countingDialer
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.dialer.closed)
+	return c.Conn.Close()
+}
+
+func TestMaxHostConns(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		// Silent open ports keep banner reads waiting for their whole timeout
+		n := NewSimNetwork()
+		for port := 1; port <= 20; port++ {
+			n.SetPort("10.0.0.1", ProtoTCP, port, SimPort{State: SimOpen, Delay: time.Millisecond})
+		}
+		dialer := &countingDialer{Dialer: n}
+
+		options := append(simOptions(dialer, time.Second),
+			WithTarget("10.0.0.1"),
+			WithPortRange(1, 20),
+			WithThreads(10),
+			WithMaxHostConns(2),
+			WithBanners(4, 20*time.Millisecond),
+		)
+		if reuse {
+			options = append(options, WithConnReuse())
+		}
+		results, err := NewScanner(options...).ScanDetailed()
+		if err != nil || len(results) != 20 {
+			t.Fatalf("reuse=%v: scan returned %d results, %v", reuse, len(results), err)
+		}
+		if dialer.peak > 2 {
+			t.Errorf("reuse=%v: %d connections to the host at once, want at most 2", reuse, dialer.peak)
+		}
+	}
+}
+
+func TestHostLimiterPacing(t *testing.T) {
+	limits := newHostLimiter(0, 20*time.Millisecond)
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limits.Pace(context.Background(), "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(started); elapsed < 40*time.Millisecond {
+		t.Errorf("3 probes to one host took %s, want at least 40ms between them", elapsed)
+	}
+
+	// Other hosts don't wait their turn
+	started = time.Now()
+	limits.Pace(context.Background(), "10.0.0.2")
+	if elapsed := time.Since(started); elapsed > 10*time.Millisecond {
+		t.Errorf("first probe to another host waited %s", elapsed)
+	}
+}
+
+func TestMultiScannerForgetsHosts(t *testing.T) {
+	n := newTestNetwork()
+	options := append(simOptions(n, 50*time.Millisecond), WithPorts(mustPorts(t, "22,80")), WithMaxHostConns(2))
+	m := NewMultiScanner(hostList([]string{"10.0.0.1", "10.0.0.2"}), options...)
+	for range m.Results() {
+	}
+	checkHostsForgotten(t, m.scanner)
+}
+
+func TestMultiScannerForgetsResumedHosts(t *testing.T) {
+	// Every port was probed before, so the host finishes as soon as it's admitted
+	cp := &Checkpoint{
+		Version: checkpointVersion,
+		Ports:   "22,80",
+		Hosts:   []HostCheckpoint{{Name: "10.0.0.1", Address: "10.0.0.1", Probed: "22,80"}},
+	}
+
+	n := newTestNetwork()
+	options := append(simOptions(n, 50*time.Millisecond), WithPorts(mustPorts(t, "22,80")), WithMaxHostConns(2), WithResume(cp))
+	m := NewMultiScanner(hostList([]string{"10.0.0.1"}), options...)
+	for range m.Results() {
+	}
+	if n.Dials() != 0 {
+		t.Errorf("%d dials to a host probed before the scan was resumed", n.Dials())
+	}
+	checkHostsForgotten(t, m.scanner)
+}
+
+// Fails unless the scanner kept no per-host state once hosts finished
+func checkHostsForgotten(t *testing.T, s *Scanner) {
+	t.Helper()
+	s.rttMutex.Lock()
+	rtts := len(s.rtts)
+	s.rttMutex.Unlock()
+	s.hostLimits.mu.Lock()
+	slots := len(s.hostLimits.hosts)
+	s.hostLimits.mu.Unlock()
+	if rtts != 0 || slots != 0 {
+		t.Errorf("%d RTT estimates and %d host slots left after the scan, want none", rtts, slots)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Smoothed round-trip time for one host, using the same maths as TCP's RTO (RFC 6298)
+type rttEstimator struct {
+	mu      sync.Mutex
+	srtt    time.Duration
+	rttvar  time.Duration
+	min     time.Duration
+	max     time.Duration
+	samples int
+}
+
+// Feeds one measured connect time into the estimate
+func (e *rttEstimator) Observe(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.samples == 0 {
+		// First sample seeds the estimate
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.min = rtt
+		e.max = rtt
+	} else {
+		// RTTVAR = 3/4 RTTVAR + 1/4 |SRTT - R|, SRTT = 7/8 SRTT + 1/8 R
+		diff := e.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar = (3*e.rttvar + diff) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+
+		if rtt < e.min {
+			e.min = rtt
+		}
+		if rtt > e.max {
+			e.max = rtt
+		}
+	}
+	e.samples++
+}
+
+// Timeout to use for the next probe, clamped to floor..ceiling.
+// Until we have a sample the initial value is used.
+func (e *rttEstimator) Timeout(initial, floor, ceiling time.Duration) time.Duration {
+	e.mu.Lock()
+	timeout := initial
+	if e.samples > 0 {
+		// RTO = SRTT + 4 * RTTVAR
+		timeout = e.srtt + 4*e.rttvar
+	}
+	e.mu.Unlock()
+
+	if timeout < floor {
+		timeout = floor
+	}
+	if timeout > ceiling {
+		timeout = ceiling
+	}
+	return timeout
+}
+
+// Snapshot of the estimate for reporting
+func (e *rttEstimator) Stats() RTTStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return RTTStats{
+		Samples: e.samples,
+		SRTT:    e.srtt,
+		RTTVar:  e.rttvar,
+		Min:     e.min,
+		Max:     e.max,
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	var e rttEstimator
+	const initial, floor, ceiling = 2 * time.Second, 10 * time.Millisecond, 5 * time.Second
+
+	if got := e.Timeout(initial, floor, ceiling); got != initial {
+		t.Errorf("timeout before any sample = %s, want the initial %s", got, initial)
+	}
+
+	// First sample: SRTT = R, RTTVAR = R/2, RTO = SRTT + 4*RTTVAR = 3R
+	e.Observe(100 * time.Millisecond)
+	if stats := e.Stats(); stats.SRTT != 100*time.Millisecond || stats.RTTVar != 50*time.Millisecond {
+		t.Errorf("after one sample SRTT=%s RTTVAR=%s, want 100ms and 50ms", stats.SRTT, stats.RTTVar)
+	}
+	if got := e.Timeout(initial, floor, ceiling); got != 300*time.Millisecond {
+		t.Errorf("timeout after one sample = %s, want 300ms", got)
+	}
+
+	// RTTVAR = 3/4*50 + 1/4*|100-180| = 57.5ms, SRTT = 7/8*100 + 1/8*180 = 110ms
+	e.Observe(180 * time.Millisecond)
+	stats := e.Stats()
+	if stats.RTTVar != 57500*time.Microsecond || stats.SRTT != 110*time.Millisecond {
+		t.Errorf("after two samples SRTT=%s RTTVAR=%s, want 110ms and 57.5ms", stats.SRTT, stats.RTTVar)
+	}
+	if got := e.Timeout(initial, floor, ceiling); got != 340*time.Millisecond {
+		t.Errorf("timeout after two samples = %s, want 340ms", got)
+	}
+	if stats.Samples != 2 || stats.Min != 100*time.Millisecond || stats.Max != 180*time.Millisecond {
+		t.Errorf("stats = %+v, want 2 samples from 100ms to 180ms", stats)
+	}
+}
+
+func TestRTTEstimatorClamp(t *testing.T) {
+	var fast, slow rttEstimator
+	fast.Observe(time.Millisecond)
+	slow.Observe(3 * time.Second)
+
+	if got := fast.Timeout(time.Second, 50*time.Millisecond, 5*time.Second); got != 50*time.Millisecond {
+		t.Errorf("fast host timeout = %s, want the 50ms floor", got)
+	}
+	if got := slow.Timeout(time.Second, 50*time.Millisecond, 5*time.Second); got != 5*time.Second {
+		t.Errorf("slow host timeout = %s, want the 5s ceiling", got)
+	}
+}
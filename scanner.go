@@ -1,265 +1,1230 @@
-package main
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"net"
-	"strconv"
-	"sync"
-	"time"
-)
-
-// Custom error type for scan failures
-type ScanError struct {
-	Host    string
-	Port    int
-	Message string
-	Err     error
-}
-
-// Standard error interface implementation
-func (e *ScanError) Error() string {
-	return fmt.Sprintf("scan error for %s:%d: %s: %v",
-		e.Host, e.Port, e.Message, e.Err)
-}
-
-// Unwrap for error chain support
-func (e *ScanError) Unwrap() error {
-	return e.Err
-}
-
-// Main scanner struct
-type Scanner struct {
-	target       string
-	startPort    int
-	endPort      int
-	threads      int
-	timeout      time.Duration
-	showProgress bool
-	ctx          context.Context
-}
-
-// For configuring scanner options
-type ScannerOption func(*Scanner)
-
-// Sets target host
-func WithTarget(target string) ScannerOption {
-	return func(s *Scanner) {
-		s.target = target
-	}
-}
-
-// Sets port range to scan
-func WithPortRange(start, end int) ScannerOption {
-	return func(s *Scanner) {
-		s.startPort = start
-		s.endPort = end
-	}
-}
-
-// Controls parallelism
-func WithThreads(n int) ScannerOption {
-	return func(s *Scanner) {
-		s.threads = n
-	}
-}
-
-// Connection timeout per port
-func WithTimeout(d time.Duration) ScannerOption {
-	return func(s *Scanner) {
-		s.timeout = d
-	}
-}
-
-// Toggle progress display
-func WithProgress(show bool) ScannerOption {
-	return func(s *Scanner) {
-		s.showProgress = show
-	}
-}
-
-// Add cancelation support
-func WithContext(ctx context.Context) ScannerOption {
-	return func(s *Scanner) {
-		s.ctx = ctx
-	}
-}
-
-// Creates a new scanner with sensible defaults
-func NewScanner(options ...ScannerOption) *Scanner {
-	// Set defaults
-	s := &Scanner{
-		target:       "localhost",
-		startPort:    1,
-		endPort:      1024,
-		threads:      100,
-		timeout:      time.Second,
-		showProgress: true,
-		ctx:          context.Background(),
-	}
-
-	// Apply any provided options
-	for _, option := range options {
-		option(s)
-	}
-
-	return s
-}
-
-// Main scanning function
-func (s *Scanner) Scan() ([]int, error) {
-	// Setup channels for work distribution
-	portCount := s.endPort - s.startPort + 1
-	ports := make(chan int, min(portCount, 1000))   // Work queue
-	results := make(chan int, min(portCount, 1000)) // Results collector
-	done := make(chan struct{})                     // Completion signal
-
-	// Handle progress display
-	progressDone := make(chan bool)
-	if s.showProgress {
-		go displayProgress(progressDone, portCount)
-	}
-
-	// Sync for all worker goroutines
-	var wg sync.WaitGroup
-
-	// Fire up workers
-	for i := 0; i < s.threads; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			for {
-				select {
-				case <-s.ctx.Done():
-					// Bail if canceled
-					return
-				case port, ok := <-ports:
-					if !ok {
-						// No more work
-						return
-					}
-
-					// Try connecting
-					isOpen, err := isPortOpen(s.ctx, s.target, port, s.timeout)
-					if err != nil {
-						// Skip errors
-						continue
-					}
-
-					if isOpen {
-						// Found an open port
-						select {
-						case results <- port:
-							// Sent to results
-						case <-s.ctx.Done():
-							// Canceled during send
-							return
-						}
-					}
-				}
-			}
-		}()
-	}
-
-	// Clean up when workers finish
-	go func() {
-		wg.Wait()
-		close(results)
-		close(done)
-	}()
-
-	// Feed ports to workers
-	go func() {
-		defer close(ports)
-
-		for port := s.startPort; port <= s.endPort; port++ {
-			select {
-			case <-s.ctx.Done():
-				return
-			case ports <- port:
-				// Sent for checking
-			}
-		}
-	}()
-
-	// Collect and process results
-	openPorts := []int{}
-	for port := range results {
-		openPorts = append(openPorts, port)
-		service := getServiceName(port)
-		banner, _ := grabBanner(s.ctx, s.target, port, s.timeout)
-		if banner != "" {
-			fmt.Printf("Port %d is open (%s): %s\n", port, service, banner)
-		} else {
-			fmt.Printf("Port %d is open (%s)\n", port, service)
-		}
-	}
-
-	// Wait till everything's done
-	<-done
-
-	// Stop progress display
-	if s.showProgress {
-		progressDone <- true
-	}
-
-	// Handle cancellation
-	select {
-	case <-s.ctx.Done():
-		return openPorts, fmt.Errorf("scan cancelled: %w", s.ctx.Err())
-	default:
-		return openPorts, nil
-	}
-}
-
-// Check if a single port is open
-func isPortOpen(ctx context.Context, host string, port int, timeout time.Duration) (bool, error) {
-	// Setup dialer with timeout
-	var d net.Dialer
-	d.Timeout = timeout
-
-	// Try to connect
-	address := net.JoinHostPort(host, strconv.Itoa(port))
-	conn, err := d.DialContext(ctx, "tcp", address)
-
-	if err != nil {
-		// Handle different error types
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return false, nil // Just closed/filtered
-		}
-
-		// Check for cancellation
-		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-			return false, err
-		}
-
-		return false, nil // Other errors = closed port
-	}
-
-	// Clean up connection
-	defer conn.Close()
-	return true, nil
-}
-
-// Quick host availability check
-func isHostAlive(ctx context.Context, host string, timeout time.Duration) bool {
-	// Check common ports
-	for _, port := range []int{80, 443, 22, 3389} {
-		isOpen, _ := isPortOpen(ctx, host, port, timeout)
-		if isOpen {
-			return true
-		}
-	}
-
-	return false
-}
-
-// Simple helper function
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Kinds of failure a ScanError can carry, check for them with errors.Is
+var (
+	ErrDNSFailure       = errors.New("could not resolve host")
+	ErrHostUnreachable  = errors.New("host unreachable")
+	ErrTooManyOpenFiles = errors.New("too many open files")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNoBufferSpace    = errors.New("no buffer space")
+	ErrSourcePortInUse  = errors.New("source port in use")
+	ErrProxyFailure     = errors.New("proxy failure")
+	ErrCancelled        = errors.New("scan cancelled")
+)
+
+// Custom error type for scan failures
+type ScanError struct {
+	Host    string
+	Port    int // 0 when the whole host or scan failed
+	Message string
+	Kind    error // One of the Err* kinds, nil for an ordinary port state
+	Err     error
+}
+
+// Standard error interface implementation
+func (e *ScanError) Error() string {
+	message := e.Message
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+
+	switch {
+	case e.Host == "":
+		return message
+	case e.Port == 0:
+		return fmt.Sprintf("scan error for %s: %s", e.Host, message)
+	default:
+		return fmt.Sprintf("scan error for %s: %s", net.JoinHostPort(e.Host, strconv.Itoa(e.Port)), message)
+	}
+}
+
+// Unwrap for error chain support
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// Makes errors.Is match the error's kind as well as its chain
+func (e *ScanError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Main scanner struct
+type Scanner struct {
+	targets      []string
+	ports        []PortTarget
+	randomize    bool
+	seed         int64
+	threads      int
+	timeout      time.Duration
+	showProgress bool
+	ctx          context.Context
+	err          error // Outcome of the last Stream call
+	progressFunc func(ScanProgress)
+
+	// Politeness controls
+	rateLimit    int
+	maxHostConns int
+	probeDelay   time.Duration
+	limiter      *rateLimiter
+	hostLimits   *hostLimiter
+	retries      int
+	retryBackoff time.Duration
+
+	// Banner stage fed by the port workers
+	bannerWorkers int
+	bannerTimeout time.Duration
+	reuseConns    bool
+
+	// Multi-host scheduling, see MultiScanner
+	allAddresses bool
+	maxHosts     int
+	aliveTimeout time.Duration
+	discovery    []DiscoveryProbe
+
+	// Where probes leave from and how they get there
+	sourceAddress string
+	sourceIface   string
+	sourcePortMin int
+	sourcePortMax int
+	proxyURL      string
+	customDialer  Dialer
+	dialer        *scanDialer
+	setupErr      error // Bad option found by NewScanner, returned by every scan
+
+	// Name resolution, once per host
+	dnsServer  string
+	reverseDNS bool
+	dns        *dnsCache
+
+	// Open file budget and worker tuning, see limitConcurrency
+	autoThreads    bool
+	raiseFileLimit bool
+	fileLimit      uint64 // Soft limit when the last scan started, 0 if unknown
+	gate           *workerGate
+
+	// Progress journal for resuming, see WithCheckpoint
+	checkpointPath    string
+	checkpointCommand []string
+	resumeFrom        *Checkpoint
+	journal           *scanJournal
+
+	// Per-host timeouts derived from measured round trips
+	adaptive   bool
+	minTimeout time.Duration
+	maxTimeout time.Duration
+	rttMutex   sync.Mutex
+	rtts       map[string]*rttEstimator
+
+	// Live counters, updated by the workers
+	started  atomic.Int64 // UnixNano of the run start
+	ended    atomic.Int64 // UnixNano of the run end, 0 while running
+	stats    *statsRecorder
+	total    atomic.Int64
+	probed   atomic.Int64
+	open     atomic.Int64
+	closed   atomic.Int64
+	filtered atomic.Int64
+	errored  atomic.Int64
+	retried  atomic.Int64
+	requeued atomic.Int64
+}
+
+// For configuring scanner options
+type ScannerOption func(*Scanner)
+
+// Sets target host
+func WithTarget(target string) ScannerOption {
+	return func(s *Scanner) {
+		s.targets = []string{target}
+	}
+}
+
+// Scans several hosts in one go, sharing the worker pool
+func WithTargets(targets ...string) ScannerOption {
+	return func(s *Scanner) {
+		s.targets = targets
+	}
+}
+
+// Probes every host/port pair in a seeded pseudo-random order. A MultiScanner
+// shuffles the ports of the hosts in flight but takes hosts in the order its
+// source gives them, TargetSpec.ShuffledHosts shuffles those too.
+func WithRandomOrder(seed int64) ScannerOption {
+	return func(s *Scanner) {
+		s.randomize = true
+		s.seed = seed
+	}
+}
+
+// Sets port range to scan
+func WithPortRange(start, end int) ScannerOption {
+	return func(s *Scanner) {
+		s.ports = portRange(start, end)
+	}
+}
+
+// Sets an explicit list of ports, e.g. from parsePortSpec
+func WithPorts(targets []PortTarget) ScannerOption {
+	return func(s *Scanner) {
+		s.ports = targets
+	}
+}
+
+// Controls parallelism
+func WithThreads(n int) ScannerOption {
+	return func(s *Scanner) {
+		s.threads = n
+	}
+}
+
+// Connection timeout per port
+func WithTimeout(d time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.timeout = d
+	}
+}
+
+// Caps the total number of probes per second across all workers
+func WithRateLimit(perSecond int) ScannerOption {
+	return func(s *Scanner) {
+		s.rateLimit = perSecond
+	}
+}
+
+// Caps concurrent connections to any single host
+func WithMaxHostConns(n int) ScannerOption {
+	return func(s *Scanner) {
+		s.maxHostConns = n
+	}
+}
+
+// Minimum gap between two probes to the same host
+func WithProbeDelay(d time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.probeDelay = d
+	}
+}
+
+// Derives each host's timeout from its measured round-trip times, kept within min..max
+func WithAdaptiveTimeout(min, max time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.adaptive = true
+		s.minTimeout = min
+		s.maxTimeout = max
+	}
+}
+
+// Re-probes ports that time out up to n more times, waiting backoff, 2*backoff, ...
+func WithRetries(n int, backoff time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.retries = n
+		s.retryBackoff = backoff
+	}
+}
+
+// Grabs the banner of every open TCP port with up to workers connections
+// at a time, waiting readTimeout for each reply (the scan timeout if 0)
+func WithBanners(workers int, readTimeout time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.bannerWorkers = workers
+		s.bannerTimeout = readTimeout
+	}
+}
+
+// Hands each open port's probe connection to the banner stage so the banner
+// is read on the same socket. A port is only connected to again when it stays
+// silent and gets an HTTP request to see if it talks.
+func WithConnReuse() ScannerOption {
+	return func(s *Scanner) {
+		s.reuseConns = true
+	}
+}
+
+// Starts with a few workers and tunes how many probe at once as the scan
+// goes, up to the thread count: halving it when timeouts or connect times
+// climb and growing it while they don't
+func WithAutoThreads() ScannerOption {
+	return func(s *Scanner) {
+		s.autoThreads = true
+	}
+}
+
+// Raises the soft limit on open files, as far as the hard limit allows, when
+// it's too low for the thread count. Without it the scan runs fewer probes
+// at once to stay under the limit.
+func WithRaiseFileLimit() ScannerOption {
+	return func(s *Scanner) {
+		s.raiseFileLimit = true
+	}
+}
+
+// Scans every A and AAAA record of a hostname as a separate target
+func WithAllAddresses() ScannerOption {
+	return func(s *Scanner) {
+		s.allAddresses = true
+	}
+}
+
+// Caps how many hosts a MultiScanner works on at once
+func WithMaxHosts(n int) ScannerOption {
+	return func(s *Scanner) {
+		s.maxHosts = n
+	}
+}
+
+// Makes a MultiScanner skip hosts that fail a quick liveness check.
+// Without it discovery is skipped and every host is treated as up.
+func WithAliveCheck(timeout time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.aliveTimeout = timeout
+	}
+}
+
+// Sets the probes the liveness check uses, TCP to common ports by default
+func WithDiscovery(probes ...DiscoveryProbe) ScannerOption {
+	return func(s *Scanner) {
+		s.discovery = probes
+	}
+}
+
+// Sends probes from a local address, e.g. 10.0.0.5 or fe80::1
+func WithSourceAddress(address string) ScannerOption {
+	return func(s *Scanner) {
+		s.sourceAddress = address
+	}
+}
+
+// Sends probes from the addresses of a network interface, e.g. eth1
+func WithInterface(name string) ScannerOption {
+	return func(s *Scanner) {
+		s.sourceIface = name
+	}
+}
+
+// Picks source ports from min to max, in turn
+func WithSourcePorts(min, max int) ScannerOption {
+	return func(s *Scanner) {
+		s.sourcePortMin = min
+		s.sourcePortMax = max
+	}
+}
+
+// Makes every probe, banner grab and discovery check connect through d,
+// e.g. a SimNetwork. Hostnames are handed to it unresolved.
+func WithDialer(d Dialer) ScannerOption {
+	return func(s *Scanner) {
+		s.customDialer = d
+	}
+}
+
+// Tunnels every TCP connection through a proxy: socks5://[user:pass@]host:port
+// (socks5h:// to let the proxy resolve names) or http://[user:pass@]host:port
+func WithProxy(proxyURL string) ScannerOption {
+	return func(s *Scanner) {
+		s.proxyURL = proxyURL
+	}
+}
+
+// Sends DNS queries to server ("10.0.0.53" or "10.0.0.53:5353") instead of the system resolver
+func WithDNSServer(server string) ScannerOption {
+	return func(s *Scanner) {
+		s.dnsServer = server
+	}
+}
+
+// Turns reverse lookups of IP targets on or off (on by default)
+func WithReverseDNS(enabled bool) ScannerOption {
+	return func(s *Scanner) {
+		s.reverseDNS = enabled
+	}
+}
+
+// Saves progress to a state file every few seconds and when the scan ends.
+// command is stored with it so a front end knows how to rebuild the scan.
+func WithCheckpoint(path string, command ...string) ScannerOption {
+	return func(s *Scanner) {
+		s.checkpointPath = path
+		s.checkpointCommand = command
+	}
+}
+
+// Continues from a checkpoint, ports it already probed are skipped and
+// its findings reported again
+func WithResume(cp *Checkpoint) ScannerOption {
+	return func(s *Scanner) {
+		s.resumeFrom = cp
+	}
+}
+
+// Toggle progress display
+func WithProgress(show bool) ScannerOption {
+	return func(s *Scanner) {
+		s.showProgress = show
+	}
+}
+
+// Add cancelation support
+func WithContext(ctx context.Context) ScannerOption {
+	return func(s *Scanner) {
+		s.ctx = ctx
+	}
+}
+
+// Hook called periodically with progress updates and once more at the end
+func WithProgressFunc(fn func(ScanProgress)) ScannerOption {
+	return func(s *Scanner) {
+		s.progressFunc = fn
+	}
+}
+
+// Creates a new scanner with sensible defaults
+func NewScanner(options ...ScannerOption) *Scanner {
+	// Set defaults
+	s := &Scanner{
+		targets:      []string{"localhost"},
+		ports:        portRange(1, 1024),
+		threads:      100,
+		timeout:      time.Second,
+		maxHosts:     8,
+		reverseDNS:   true,
+		showProgress: true,
+		ctx:          context.Background(),
+		rtts:         make(map[string]*rttEstimator),
+		stats:        newStatsRecorder(),
+	}
+
+	// Apply any provided options
+	for _, option := range options {
+		option(s)
+	}
+
+	// Work out the route once, a bad address or proxy fails the scan before it starts
+	source, err := newSourceBinding(s.sourceAddress, s.sourceIface, s.sourcePortMin, s.sourcePortMax)
+	proxy, proxyErr := parseProxy(s.proxyURL)
+	if err == nil {
+		err = proxyErr
+	}
+	if err == nil && s.customDialer != nil && source != nil {
+		err = fmt.Errorf("a custom dialer can't be combined with a source address, interface or port")
+	}
+	s.dialer, s.setupErr = newScanDialer(s.customDialer, source, proxy), err
+
+	// A damaged checkpoint fails the scan too
+	journal, err := newScanJournal(s.checkpointPath, s.checkpointCommand, s.ports, s.resumeFrom)
+	if s.setupErr == nil {
+		s.setupErr = err
+	}
+	s.journal = journal
+
+	// One cache for every lookup this scanner makes, names are left to
+	// proxies and custom dialers that resolve them on the far side
+	s.dns = newDNSCache(s.dnsServer)
+	s.dns.passNames = s.customDialer != nil || (proxy != nil && proxy.resolvesNames())
+
+	// Shared throttles, only when asked for
+	if s.rateLimit > 0 {
+		s.limiter = newRateLimiter(s.rateLimit)
+	}
+	if s.maxHostConns > 0 || s.probeDelay > 0 {
+		s.hostLimits = newHostLimiter(s.maxHostConns, s.probeDelay)
+	}
+
+	return s
+}
+
+// Main scanning function
+func (s *Scanner) Scan() ([]int, error) {
+	// Keep only the open ports
+	openPorts := []int{}
+	err := s.run(func(result PortResult) {
+		if result.Status == StatusOpen {
+			openPorts = append(openPorts, result.Port)
+		}
+	})
+
+	return openPorts, err
+}
+
+// Streams results as ports are probed, the channel closes when the scan ends.
+// Check Err afterwards to see if the scan was cut short. Callers must read
+// until the channel closes or cancel the scan's context (WithContext) to
+// stop early, otherwise the scan blocks on the next result forever.
+func (s *Scanner) Stream() <-chan PortResult {
+	out := make(chan PortResult)
+
+	go func() {
+		defer close(out)
+
+		s.err = s.run(func(result PortResult) {
+			select {
+			case out <- result:
+				// Delivered
+			case <-s.ctx.Done():
+				// Nobody is listening anymore
+			}
+		})
+	}()
+
+	return out
+}
+
+// Error from the last streamed scan, valid once the channel is closed
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Scans every port and reports its state, not just the open ones
+func (s *Scanner) ScanDetailed() ([]PortResult, error) {
+	results := []PortResult{}
+	err := s.run(func(result PortResult) {
+		results = append(results, result)
+	})
+
+	// Workers finish out of order
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Host != results[j].Host {
+			return results[i].Host < results[j].Host
+		}
+		if results[i].Proto != results[j].Proto {
+			return results[i].Proto < results[j].Proto
+		}
+		return results[i].Port < results[j].Port
+	})
+
+	return results, err
+}
+
+// Catches settings that would fail every probe, before any worker starts
+func (s *Scanner) preflight() error {
+	switch {
+	case s.setupErr != nil:
+		return s.setupErr
+	case len(s.ports) == 0:
+		return fmt.Errorf("no ports to scan")
+	case s.threads < 1:
+		return fmt.Errorf("need at least one thread, got %d", s.threads)
+	case s.timeout <= 0:
+		return fmt.Errorf("timeout must be positive, got %s", s.timeout)
+	}
+
+	// Proxies only tunnel TCP, one error beats one per UDP port
+	if s.dialer.proxy != nil {
+		for _, target := range s.ports {
+			if target.Proto == ProtoUDP {
+				return fmt.Errorf("UDP ports can't be scanned through a proxy, scan them without one")
+			}
+		}
+	}
+
+	// A dead proxy would fail every probe the same way
+	if err := s.dialer.checkProxy(s.ctx, s.longestProbe()); err != nil {
+		if s.ctx.Err() != nil {
+			return s.cancelled()
+		}
+		return err
+	}
+	return nil
+}
+
+// Runs the worker pool, handing every probe result to handle
+func (s *Scanner) run(handle func(PortResult)) error {
+	if err := s.preflight(); err != nil {
+		return err
+	}
+	s.limitConcurrency()
+
+	// Resolve every name once up front, a typo shouldn't cost thousands of failed dials
+	targets := []hostEntry{}
+	for _, target := range s.targets {
+		entries, err := s.dns.hostEntries(s.ctx, normalizeHost(target), s.allAddresses)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return s.cancelled()
+			}
+			return &ScanError{Host: target, Message: "could not resolve host", Kind: ErrDNSFailure, Err: err}
+		}
+		targets = append(targets, entries...)
+	}
+
+	// Setup channels for work distribution
+	portCount := len(targets) * len(s.ports)
+	work := make(chan probeJob, min(portCount, 1000))      // Work queue
+	results := make(chan PortResult, min(portCount, 1000)) // Results collector
+
+	// Reset counters for this run
+	s.resetProgress(portCount)
+
+	// Report what an earlier run found, its ports count as done
+	for _, entry := range targets {
+		for _, target := range s.ports {
+			if s.journal.wasProbed(entry.address, target) {
+				s.probed.Add(1)
+			}
+		}
+		for _, info := range s.journal.resumedOpen(entry.address) {
+			s.open.Add(1)
+			handle(PortResult{
+				Host:    entry.name,
+				Address: entry.address,
+				Port:    info.Port,
+				Proto:   info.Proto,
+				Status:  StatusOpen,
+				Banner:  info.Banner,
+			})
+		}
+	}
+	stopReporting := s.startReporting()
+	stopJournal := s.journal.start()
+
+	// Fire up workers, results closes once they're all done
+	s.startWorkers(work, results)
+
+	// Feed ports to workers
+	go s.feed(targets, work)
+
+	// Hand results over as they arrive
+	for result := range results {
+		handle(result)
+	}
+
+	// Stop progress display
+	stopReporting()
+
+	return s.finish(stopJournal)
+}
+
+// Stops the clock and saves the final checkpoint, a cancelled scan is left resumable
+func (s *Scanner) finish(stopJournal func(complete bool) error) error {
+	s.ended.Store(time.Now().UnixNano())
+	cancelled := s.cancelled()
+	if err := stopJournal(cancelled == nil); err != nil && cancelled == nil {
+		return err
+	}
+	return cancelled
+}
+
+// Starts the progress bar and hook, the returned func stops them after a final update
+func (s *Scanner) startReporting() func() {
+	// Handle progress display
+	progressDone := make(chan bool)
+	if s.showProgress {
+		go displayProgress(progressDone, s.Progress)
+	}
+
+	// Feed the progress hook
+	hookDone := make(chan bool)
+	if s.progressFunc != nil {
+		go s.reportProgress(hookDone)
+	}
+
+	return func() {
+		if s.showProgress {
+			progressDone <- true
+			<-progressDone // Final bar has been drawn
+		}
+		if s.progressFunc != nil {
+			hookDone <- true
+			<-hookDone // Final report delivered
+		}
+	}
+}
+
+// Fires up the worker pool and banner stage, closing results once every worker has exited
+func (s *Scanner) startWorkers(work <-chan probeJob, results chan<- PortResult) {
+	// Open TCP ports take a detour through the banner stage, only a few
+	// at a time when each one holds a connection open
+	var grabs chan PortResult
+	if s.bannerWorkers > 0 && s.reuseConns {
+		grabs = make(chan PortResult, s.bannerWorkers)
+	} else if s.bannerWorkers > 0 {
+		grabs = make(chan PortResult, 1000)
+	}
+
+	// Sync for all worker goroutines
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-s.ctx.Done():
+					// Bail if canceled
+					return
+				case job, ok := <-work:
+					if !ok {
+						// No more work
+						return
+					}
+
+					result, ok := s.probeJob(job)
+					if !ok {
+						// Cancelled while waiting or mid-probe
+						return
+					}
+					s.countProbe(result.Status)
+					s.stats.add(result)
+
+					// The banner stage records it once the banner is in
+					out := results
+					if grabs != nil && result.Status == StatusOpen && result.Proto == ProtoTCP {
+						out = grabs
+					} else {
+						s.journal.recordProbe(result)
+					}
+
+					select {
+					case out <- result:
+						// Sent to results
+					case <-s.ctx.Done():
+						// Canceled during send
+						closeProbeConn(&result)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// Banner workers, slow services only hold up each other
+	var bannerWg sync.WaitGroup
+	for i := 0; i < s.bannerWorkers; i++ {
+		bannerWg.Add(1)
+		go func() {
+			defer bannerWg.Done()
+
+			// Keeps draining after a cancel so held connections get closed
+			for result := range grabs {
+				s.addBanner(&result)
+				if s.ctx.Err() != nil {
+					// Cancelled mid-grab, leave it for a resume
+					continue
+				}
+				s.journal.recordProbe(result)
+
+				select {
+				case results <- result:
+					// Sent to results
+				case <-s.ctx.Done():
+				}
+			}
+		}()
+	}
+
+	// Clean up when workers finish, the banner stage drains after the port workers
+	go func() {
+		wg.Wait()
+		if grabs != nil {
+			close(grabs)
+		}
+		bannerWg.Wait()
+		close(results)
+	}()
+}
+
+// Reads an open port's banner while respecting the rate and host limits
+func (s *Scanner) addBanner(result *PortResult) {
+	if result.conn != nil {
+		s.addBannerOnProbeConn(result)
+		return
+	}
+
+	release, err := s.throttle(result.Address)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	result.Banner, _ = grabBanner(s.ctx, s.dialer, result.Address, result.Port,
+		s.timeoutFor(result.Address), s.bannerReadTimeout())
+}
+
+// Reads the banner on the connection the probe left open, only dialling
+// again to send a silent service an HTTP request
+func (s *Scanner) addBannerOnProbeConn(result *PortResult) {
+	request := bannerRequest(result.Address, result.Port)
+	result.Banner, _ = readBanner(result.conn, request, s.bannerReadTimeout())
+	result.conn.Close()
+	result.conn = nil
+	if result.releaseHost != nil {
+		defer result.releaseHost()
+		result.releaseHost = nil
+	}
+	if result.Banner != "" || request != "" || s.ctx.Err() != nil {
+		return
+	}
+
+	// Waiting on the old socket may have worn out the service's patience.
+	// The new connection takes over the probe's host slot, waiting for
+	// another could stall behind ports queued for this stage.
+	if s.pace(result.Address) != nil {
+		return
+	}
+
+	result.Banner, _ = probeBanner(s.ctx, s.dialer, result.Address, result.Port,
+		httpRequest(result.Address), s.timeoutFor(result.Address), s.bannerReadTimeout())
+}
+
+// Closes the connection a probe kept for the banner stage, if any, and
+// gives its host connection slot back
+func closeProbeConn(result *PortResult) {
+	if result.conn != nil {
+		result.conn.Close()
+		result.conn = nil
+	}
+	if result.releaseHost != nil {
+		result.releaseHost()
+		result.releaseHost = nil
+	}
+}
+
+// How long to wait for a banner once connected
+func (s *Scanner) bannerReadTimeout() time.Duration {
+	if s.bannerTimeout > 0 {
+		return s.bannerTimeout
+	}
+	return s.timeout
+}
+
+// Error to return if the scan's context was cancelled
+func (s *Scanner) cancelled() error {
+	select {
+	case <-s.ctx.Done():
+		return &ScanError{Message: "scan cancelled", Kind: ErrCancelled, Err: s.ctx.Err()}
+	default:
+		return nil
+	}
+}
+
+// Current progress of the running (or last) scan
+func (s *Scanner) Progress() ScanProgress {
+	return ScanProgress{
+		Total:    int(s.total.Load()),
+		Probed:   int(s.probed.Load()),
+		Open:     int(s.open.Load()),
+		Closed:   int(s.closed.Load()),
+		Filtered: int(s.filtered.Load()),
+		Errors:   int(s.errored.Load()),
+		Retries:  int(s.retried.Load()),
+		Elapsed:  time.Since(time.Unix(0, s.started.Load())),
+	}
+}
+
+// Statistics for the running (or last) scan
+func (s *Scanner) Stats() ScanStats {
+	started := s.started.Load()
+	elapsed := time.Since(time.Unix(0, started))
+	if ended := s.ended.Load(); ended != 0 {
+		elapsed = time.Duration(ended - started)
+	}
+	stats := s.stats.snapshot(elapsed)
+	stats.Threads = s.threads
+	stats.Concurrency = s.gate.Limit()
+	stats.PeakConcurrency = s.gate.Peak()
+	stats.AutoThreads = s.autoThreads
+	stats.FileLimit = s.fileLimit
+	stats.Requeued = int(s.requeued.Load())
+	return stats
+}
+
+// Zero the counters before a run
+func (s *Scanner) resetProgress(total int) {
+	s.started.Store(time.Now().UnixNano())
+	s.ended.Store(0)
+	s.stats.reset()
+	s.total.Store(int64(total))
+	s.probed.Store(0)
+	s.open.Store(0)
+	s.closed.Store(0)
+	s.filtered.Store(0)
+	s.errored.Store(0)
+	s.retried.Store(0)
+	s.requeued.Store(0)
+}
+
+// Record a finished probe
+func (s *Scanner) countProbe(status PortStatus) {
+	switch status {
+	case StatusOpen:
+		s.open.Add(1)
+	case StatusClosed:
+		s.closed.Add(1)
+	case StatusFiltered, StatusOpenFiltered:
+		s.filtered.Add(1)
+	default:
+		s.errored.Add(1)
+	}
+	s.probed.Add(1)
+}
+
+// Calls the progress hook until the scan is done
+func (s *Scanner) reportProgress(done chan bool) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			// Final numbers
+			s.progressFunc(s.Progress())
+			done <- true
+			return
+		case <-ticker.C:
+			s.progressFunc(s.Progress())
+		}
+	}
+}
+
+// Waits on the rate limiter and per-host limits before connecting to host
+func (s *Scanner) throttle(host string) (func(), error) {
+	release := func() {}
+
+	if s.hostLimits != nil {
+		var err error
+		release, err = s.hostLimits.Acquire(s.ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.limiter != nil {
+		if err := s.limiter.Wait(s.ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// Like throttle, for a connection that already holds its host's slot
+func (s *Scanner) pace(host string) error {
+	if s.hostLimits != nil {
+		if err := s.hostLimits.Pace(s.ctx, host); err != nil {
+			return err
+		}
+	}
+	if s.limiter != nil {
+		return s.limiter.Wait(s.ctx)
+	}
+	return nil
+}
+
+// Grabs a banner while respecting the scanner's rate and host limits
+func (s *Scanner) Banner(host string, port int) (string, error) {
+	if s.setupErr != nil {
+		return "", s.setupErr
+	}
+
+	release, err := s.throttle(host)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	return grabBanner(s.ctx, s.dialer, host, port, s.timeout, s.bannerReadTimeout())
+}
+
+// One unit of work for the pool
+type probeJob struct {
+	host    string // Name to report
+	address string // Address to dial
+	target  PortTarget
+}
+
+// Sends every host/port pair to the workers, in order or shuffled
+func (s *Scanner) feed(targets []hostEntry, work chan<- probeJob) {
+	defer close(work)
+
+	portCount := uint64(len(s.ports))
+	total := uint64(len(targets)) * portCount
+
+	// Hosts in order, each host's ports in order
+	next := sequence(total)
+
+	// Spread probes over the whole host x port space instead
+	if s.randomize {
+		next = newPermutation(total, s.seed).Next
+	}
+
+	for {
+		index, ok := next()
+		if !ok {
+			return
+		}
+
+		entry := targets[index/portCount]
+		job := probeJob{
+			host:    entry.name,
+			address: entry.address,
+			target:  s.ports[index%portCount],
+		}
+
+		// Done before the scan was resumed
+		if s.journal.wasProbed(job.address, job.target) {
+			continue
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case work <- job:
+			// Sent for checking
+		}
+	}
+}
+
+// Probe one target, retrying timeouts if asked to. Every attempt waits
+// its turn like a probe of its own. Also returns when the first attempt
+// started, or an error if the scan was cancelled while waiting.
+func (s *Scanner) probe(host string, target PortTarget) (PortResult, time.Time, error) {
+	result := PortResult{Host: host, Address: host, Port: target.Port, Proto: target.Proto}
+	var started time.Time
+
+	for {
+		// Wait for our turn
+		release, err := s.throttle(host)
+		if err != nil {
+			return result, started, err
+		}
+		leave, err := s.gate.Acquire(s.ctx)
+		if err != nil {
+			release()
+			return result, started, err
+		}
+
+		// Try connecting
+		result.Attempts++
+		start := time.Now()
+		if started.IsZero() {
+			started = start
+		}
+		result.Status, result.conn, result.Err = s.probeOnce(host, target)
+		leave()
+		if result.conn != nil {
+			// The banner stage reads on the connection, it counts
+			// against the host's cap until closed
+			result.releaseHost = release
+		} else {
+			release()
+		}
+
+		// Only a real answer (SYN-ACK, RST or a reply) tells us the round trip
+		result.RTT = 0
+		if result.Status == StatusOpen || result.Status == StatusClosed {
+			result.RTT = time.Since(start)
+			s.rttFor(host).Observe(result.RTT)
+		}
+
+		// Only silence is worth another try, a refusal is definite
+		if result.Attempts > s.retries || !isTimeoutError(result.Err) {
+			return result, started, nil
+		}
+
+		// Back off a little more each time
+		backoff := s.retryBackoff << (result.Attempts - 1)
+		if sleepContext(s.ctx, backoff) != nil {
+			return result, started, nil
+		}
+		s.retried.Add(1)
+	}
+}
+
+// Single connection attempt with the right protocol, an open TCP port's
+// connection is returned when the banner stage is going to reuse it
+func (s *Scanner) probeOnce(host string, target PortTarget) (PortStatus, net.Conn, error) {
+	var status PortStatus
+	var conn net.Conn
+	var err error
+	if target.Proto == ProtoUDP {
+		status, err = probeUDP(s.ctx, s.dialer, host, target.Port, s.timeoutFor(host))
+	} else {
+		status, conn, err = probeConn(s.ctx, s.dialer, host, target.Port, s.timeoutFor(host))
+	}
+
+	if conn != nil && !(s.reuseConns && s.bannerWorkers > 0) {
+		conn.Close()
+		conn = nil
+	}
+	return status, conn, err
+}
+
+// Gets or creates the round-trip tracker for host
+func (s *Scanner) rttFor(host string) *rttEstimator {
+	s.rttMutex.Lock()
+	defer s.rttMutex.Unlock()
+
+	rtt, exists := s.rtts[host]
+	if !exists {
+		rtt = &rttEstimator{}
+		s.rtts[host] = rtt
+	}
+	return rtt
+}
+
+// Drops what the scanner tracks for a host that's done, so a long range
+// doesn't keep state for every host it ever scanned
+func (s *Scanner) forgetHost(host string) {
+	s.rttMutex.Lock()
+	delete(s.rtts, host)
+	s.rttMutex.Unlock()
+
+	if s.hostLimits != nil {
+		s.hostLimits.forget(host)
+	}
+}
+
+// Dial timeout for the next probe to host
+func (s *Scanner) timeoutFor(host string) time.Duration {
+	if !s.adaptive {
+		return s.timeout
+	}
+	return s.rttFor(host).Timeout(s.timeout, s.minTimeout, s.maxTimeout)
+}
+
+// Reverse DNS names for an address, empty if there are none or lookups are off
+func (s *Scanner) ReverseNames(address string) []string {
+	if !s.reverseDNS {
+		return nil
+	}
+	return s.dns.LookupNames(s.ctx, address)
+}
+
+// Round-trip statistics measured for host so far
+func (s *Scanner) RTTStats(host string) RTTStats {
+	stats := s.rttFor(host).Stats()
+	stats.Timeout = s.timeoutFor(host)
+	return stats
+}
+
+// Probe a single port and classify the outcome
+func probePort(ctx context.Context, dialer Dialer, host string, port int, timeout time.Duration) (PortStatus, error) {
+	status, conn, err := probeConn(ctx, dialer, host, port, timeout)
+	if conn != nil {
+		// Clean up connection
+		conn.Close()
+	}
+	return status, err
+}
+
+// Like probePort, but hands back the connection to an open port for the caller to close
+func probeConn(ctx context.Context, dialer Dialer, host string, port int, timeout time.Duration) (PortStatus, net.Conn, error) {
+	// Give up on the connect after the timeout
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Try to connect
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := dialer.DialContext(dialCtx, "tcp", address)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Scan itself was cancelled, says nothing about the port
+			return StatusError, nil, &ScanError{Host: host, Port: port, Message: "cancelled", Kind: ErrCancelled, Err: ctx.Err()}
+		}
+
+		status, scanErr := dialFailure(host, port, err)
+		return status, nil, scanErr
+	}
+
+	return StatusOpen, conn, nil
+}
+
+// Wraps a failed dial in a ScanError and works out what it means for the port
+func dialFailure(host string, port int, err error) (PortStatus, error) {
+	status, message, kind := classifyDialError(err)
+	return status, &ScanError{Host: host, Port: port, Message: message, Kind: kind, Err: err}
+}
+
+// Map a dial error onto a port state, and a failure kind if it's more than a port state
+func classifyDialError(err error) (PortStatus, string, error) {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		// Got a RST back
+		return StatusClosed, "connection refused", nil
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH),
+		errors.Is(err, syscall.EHOSTDOWN):
+		// ICMP unreachable, usually a firewall or routing problem
+		return StatusFiltered, "unreachable", ErrHostUnreachable
+	case isTimeoutError(err):
+		// Nothing came back at all
+		return StatusFiltered, "timed out", nil
+	}
+
+	// The rest are local problems that say nothing about the port
+	switch {
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return StatusError, "too many open files", ErrTooManyOpenFiles
+	case errors.Is(err, syscall.ENOBUFS):
+		return StatusError, "no buffer space", ErrNoBufferSpace
+	case errors.Is(err, syscall.EADDRINUSE), errors.Is(err, syscall.EADDRNOTAVAIL):
+		return StatusError, "source port in use", ErrSourcePortInUse
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return StatusError, "permission denied", ErrPermissionDenied
+	case errors.Is(err, ErrProxyFailure):
+		return StatusError, "proxy failure", ErrProxyFailure
+	case errors.As(err, &dnsErr):
+		return StatusError, "could not resolve host", ErrDNSFailure
+	}
+	return StatusError, "dial failed", nil
+}
+
+// Reports whether a probe failed because the system ran out of sockets or
+// source ports, worth trying again with fewer probes in flight
+func isResourceExhausted(err error) bool {
+	return errors.Is(err, ErrTooManyOpenFiles) || errors.Is(err, ErrNoBufferSpace) ||
+		errors.Is(err, ErrSourcePortInUse)
+}
+
+// Reports whether a dial failed because nothing answered in time
+func isTimeoutError(err error) bool {
+	if errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+
+	// Dial timeouts show up as net.Error
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Check if a single port is open
+func isPortOpen(ctx context.Context, dialer Dialer, host string, port int, timeout time.Duration) (bool, error) {
+	status, err := probePort(ctx, dialer, host, port, timeout)
+
+	// Check for cancellation
+	if ctx.Err() != nil {
+		return false, err
+	}
+
+	// Closed, filtered and local errors all count as not open
+	return status == StatusOpen, nil
+}
+
+// Quick host availability check using the scanner's discovery probes
+func (s *Scanner) isHostAlive(host string) bool {
+	method, _ := discoverHost(s.ctx, s.dialer, host, s.aliveTimeout, s.discovery)
+	return method != ""
+}
+
+// Simple helper function
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
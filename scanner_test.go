@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A dial error the way the net package reports errno
+func dialErrno(errno syscall.Errno) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+}
+
+func TestClassifyDialError(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  PortStatus
+		message string
+		kind    error
+	}{
+		{dialErrno(syscall.ECONNREFUSED), StatusClosed, "connection refused", nil},
+		{dialErrno(syscall.ECONNRESET), StatusClosed, "connection refused", nil},
+		{dialErrno(syscall.EHOSTUNREACH), StatusFiltered, "unreachable", ErrHostUnreachable},
+		{dialErrno(syscall.ENETUNREACH), StatusFiltered, "unreachable", ErrHostUnreachable},
+		{dialErrno(syscall.ETIMEDOUT), StatusFiltered, "timed out", nil},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, StatusFiltered, "timed out", nil},
+		{dialErrno(syscall.EMFILE), StatusError, "too many open files", ErrTooManyOpenFiles},
+		{dialErrno(syscall.ENFILE), StatusError, "too many open files", ErrTooManyOpenFiles},
+		{dialErrno(syscall.ENOBUFS), StatusError, "no buffer space", ErrNoBufferSpace},
+		{dialErrno(syscall.EADDRINUSE), StatusError, "source port in use", ErrSourcePortInUse},
+		{dialErrno(syscall.EADDRNOTAVAIL), StatusError, "source port in use", ErrSourcePortInUse},
+		{dialErrno(syscall.EACCES), StatusError, "permission denied", ErrPermissionDenied},
+		{dialErrno(syscall.EPERM), StatusError, "permission denied", ErrPermissionDenied},
+		{fmt.Errorf("socks5: %w", ErrProxyFailure), StatusError, "proxy failure", ErrProxyFailure},
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nowhere.example"}}, StatusError, "could not resolve host", ErrDNSFailure},
+		{dialErrno(syscall.EINVAL), StatusError, "dial failed", nil},
+		{errors.New("something else"), StatusError, "dial failed", nil},
+	}
+	for _, tt := range tests {
+		status, message, kind := classifyDialError(tt.err)
+		if status != tt.status || message != tt.message || kind != tt.kind {
+			t.Errorf("classifyDialError(%v) = %v, %q, %v, want %v, %q, %v", tt.err, status, message, kind, tt.status, tt.message, tt.kind)
+		}
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	status, err := dialFailure("10.0.0.1", 22, dialErrno(syscall.EMFILE))
+	if status != StatusError || !errors.Is(err, ErrTooManyOpenFiles) || !errors.Is(err, syscall.EMFILE) {
+		t.Errorf("dialFailure = %v, %v, want an error of kind ErrTooManyOpenFiles wrapping EMFILE", status, err)
+	}
+	if !isResourceExhausted(err) {
+		t.Errorf("isResourceExhausted(%v) = false", err)
+	}
+	if _, err := dialFailure("10.0.0.1", 22, dialErrno(syscall.EACCES)); isResourceExhausted(err) {
+		t.Errorf("isResourceExhausted(%v) = true", err)
+	}
+}
+
+func TestProbePortCancelled(t *testing.T) {
+	n := NewSimNetwork()
+	n.Filter("10.0.0.1", 22)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status, err := probePort(ctx, n, "10.0.0.1", 22, time.Second)
+	if status != StatusError || !errors.Is(err, ErrCancelled) {
+		t.Errorf("probePort after cancelling = %v, %v, want an error of kind ErrCancelled", status, err)
+	}
+}
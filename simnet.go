@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How a simulated port behaves
+type SimPortState int
+
+const (
+	SimClosed   SimPortState = iota // Refuses connections
+	SimOpen                         // Accepts and sends its banner
+	SimFiltered                     // Never answers
+)
+
+// A simulated port: its state, how long it takes to answer and what it says
+type SimPort struct {
+	State  SimPortState
+	Delay  time.Duration // Answer this much later, slow hosts and tarpits
+	Banner string        // Sent on connect, or as the reply to a UDP probe
+}
+
+// In-memory network implementing Dialer, so whole scans can run without
+// touching the real one. Hosts are matched on the exact string dialled,
+// ports nobody declared are closed on known hosts and filtered elsewhere.
+type SimNetwork struct {
+	mu    sync.Mutex
+	hosts map[string]map[simKey]SimPort
+	dials int
+}
+
+// Port and protocol a simulated port answers on
+type simKey struct {
+	proto Protocol
+	port  int
+}
+
+// Creates an empty simulated network
+func NewSimNetwork() *SimNetwork {
+	return &SimNetwork{hosts: make(map[string]map[simKey]SimPort)}
+}
+
+// Declares a port on host, adding the host if it's new
+func (n *SimNetwork) SetPort(host string, proto Protocol, port int, behaviour SimPort) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ports, exists := n.hosts[host]
+	if !exists {
+		ports = make(map[simKey]SimPort)
+		n.hosts[host] = ports
+	}
+	ports[simKey{proto, port}] = behaviour
+}
+
+// Adds a host with every port closed
+func (n *SimNetwork) AddHost(host string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, exists := n.hosts[host]; !exists {
+		n.hosts[host] = make(map[simKey]SimPort)
+	}
+}
+
+// Opens a TCP port that greets clients with banner
+func (n *SimNetwork) Open(host string, port int, banner string) {
+	n.SetPort(host, ProtoTCP, port, SimPort{State: SimOpen, Banner: banner})
+}
+
+// Makes a TCP port drop every connection attempt
+func (n *SimNetwork) Filter(host string, port int) {
+	n.SetPort(host, ProtoTCP, port, SimPort{State: SimFiltered})
+}
+
+// Number of connections attempted so far
+func (n *SimNetwork) Dials() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dials
+}
+
+// Looks up how a port behaves
+func (n *SimNetwork) port(host string, proto Protocol, port int) SimPort {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.dials++
+	ports, exists := n.hosts[host]
+	if !exists {
+		// Unknown hosts swallow everything
+		return SimPort{State: SimFiltered}
+	}
+	return ports[simKey{proto, port}] // Closed unless declared
+}
+
+func (n *SimNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	proto := ProtoTCP
+	if network == "udp" || network == "udp4" || network == "udp6" {
+		proto = ProtoUDP
+	}
+	behaviour := n.port(normalizeHost(host), proto, port)
+
+	// TCP needs an answer to connect, UDP "connects" without one
+	if proto == ProtoTCP {
+		if behaviour.State == SimFiltered {
+			<-ctx.Done()
+			return nil, simDialError(ctx, network)
+		}
+		if sleepContext(ctx, behaviour.Delay) != nil {
+			return nil, simDialError(ctx, network)
+		}
+		if behaviour.State == SimClosed {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+		}
+	}
+
+	client, server := net.Pipe()
+	if proto == ProtoUDP {
+		go serveSimUDP(server, behaviour)
+		return &simUDPConn{Conn: client, refused: behaviour.State == SimClosed}, nil
+	}
+
+	go serveSimTCP(server, behaviour)
+	return client, nil
+}
+
+// Error for a dial cut short by the context, a timeout if its deadline passed
+func simDialError(ctx context.Context, network string) error {
+	err := ctx.Err()
+	if err == context.DeadlineExceeded {
+		err = os.ErrDeadlineExceeded
+	}
+	return &net.OpError{Op: "dial", Net: network, Err: err}
+}
+
+// Server side of a simulated TCP connection: greet, then ignore the client
+func serveSimTCP(conn net.Conn, behaviour SimPort) {
+	defer conn.Close()
+
+	// Pipes are synchronous, so write while we read
+	if behaviour.Banner != "" {
+		go conn.Write([]byte(behaviour.Banner))
+	}
+
+	// Hold the connection open until the client is done with it
+	io.Copy(io.Discard, conn)
+}
+
+// Server side of a simulated UDP exchange: open ports answer each datagram
+// after the delay, closed and filtered ones stay silent
+func serveSimUDP(conn net.Conn, behaviour SimPort) {
+	defer conn.Close()
+
+	buffer := make([]byte, 1500)
+	for {
+		if _, err := conn.Read(buffer); err != nil {
+			return
+		}
+		if behaviour.State != SimOpen {
+			continue
+		}
+
+		time.Sleep(behaviour.Delay)
+		reply := behaviour.Banner
+		if reply == "" {
+			reply = "\x00"
+		}
+		go conn.Write([]byte(reply))
+	}
+}
+
+// Client side of a simulated UDP socket. Reads on a closed port fail the way
+// an ICMP port unreachable makes them fail on Linux.
+type simUDPConn struct {
+	net.Conn
+	refused bool
+}
+
+func (c *simUDPConn) Read(b []byte) (int, error) {
+	if c.refused {
+		return 0, &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// One host with a port in every state, plus a second host with SSH only
+func newTestNetwork() *SimNetwork {
+	n := NewSimNetwork()
+	n.Open("10.0.0.1", 22, "SSH-2.0-Sim\r\n")
+	n.Open("10.0.0.1", 80, "")
+	n.Filter("10.0.0.1", 443)
+	n.SetPort("10.0.0.1", ProtoTCP, 8080, SimPort{State: SimOpen, Delay: 50 * time.Millisecond, Banner: "slow\r\n"})
+	n.SetPort("10.0.0.1", ProtoUDP, 53, SimPort{State: SimOpen, Banner: "dns"})
+	n.Open("10.0.0.2", 22, "SSH-2.0-Other\r\n")
+	return n
+}
+
+// Options every simulated scan needs
+func simOptions(n Dialer, timeout time.Duration) []ScannerOption {
+	return []ScannerOption{
+		WithDialer(n),
+		WithTimeout(timeout),
+		WithProgress(false),
+		WithReverseDNS(false),
+	}
+}
+
+// Parses a port spec the test knows is valid
+func mustPorts(t *testing.T, spec string) []PortTarget {
+	t.Helper()
+	ports, err := parsePortSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ports
+}
+
+// Runs a single-host scan and returns the results by port
+func simScan(t *testing.T, host, spec string, options ...ScannerOption) (map[PortTarget]PortResult, *Scanner) {
+	t.Helper()
+	s := NewScanner(append([]ScannerOption{WithTarget(host), WithPorts(mustPorts(t, spec))}, options...)...)
+	results, err := s.ScanDetailed()
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	byPort := make(map[PortTarget]PortResult)
+	for _, result := range results {
+		byPort[PortTarget{Port: result.Port, Proto: result.Proto}] = result
+	}
+	return byPort, s
+}
+
+func TestSimScanStates(t *testing.T) {
+	n := newTestNetwork()
+	results, _ := simScan(t, "10.0.0.1", "22,80,443,8080,9999,U:53", simOptions(n, 200*time.Millisecond)...)
+
+	tests := []struct {
+		target PortTarget
+		want   PortStatus
+	}{
+		{PortTarget{22, ProtoTCP}, StatusOpen},
+		{PortTarget{80, ProtoTCP}, StatusOpen},
+		{PortTarget{443, ProtoTCP}, StatusFiltered},
+		{PortTarget{8080, ProtoTCP}, StatusOpen},
+		{PortTarget{9999, ProtoTCP}, StatusClosed},
+		{PortTarget{53, ProtoUDP}, StatusOpen},
+	}
+	for _, tt := range tests {
+		if got := results[tt.target].Status; got != tt.want {
+			t.Errorf("%d/%s is %v, want %v", tt.target.Port, tt.target.Proto, got, tt.want)
+		}
+	}
+	if rtt := results[PortTarget{8080, ProtoTCP}].RTT; rtt < 50*time.Millisecond {
+		t.Errorf("slow port answered in %s, want at least its 50ms delay", rtt)
+	}
+}
+
+func TestSimScanSlowPortTimesOut(t *testing.T) {
+	n := newTestNetwork()
+	results, _ := simScan(t, "10.0.0.1", "22,8080", simOptions(n, 20*time.Millisecond)...)
+
+	if got := results[PortTarget{22, ProtoTCP}].Status; got != StatusOpen {
+		t.Errorf("22 is %v, want open", got)
+	}
+	if got := results[PortTarget{8080, ProtoTCP}].Status; got != StatusFiltered {
+		t.Errorf("8080 answering after the timeout is %v, want filtered", got)
+	}
+}
+
+func TestSimMultiScanner(t *testing.T) {
+	n := newTestNetwork()
+	options := append(simOptions(n, 100*time.Millisecond),
+		WithPorts(mustPorts(t, "22,80,443")),
+		WithAliveCheck(50*time.Millisecond),
+		WithDiscovery(TCPDiscovery{Ports: []int{22}}),
+	)
+	m := NewMultiScanner(hostList([]string{"10.0.0.1", "10.0.0.2", "10.9.9.9"}), options...)
+
+	open := make(map[string][]int)
+	down := []string{}
+	for result := range m.Results() {
+		if result.Err != nil {
+			t.Errorf("%s failed: %v", result.Host, result.Err)
+		}
+		if result.Down {
+			down = append(down, result.Host)
+			continue
+		}
+		for _, info := range result.Ports {
+			open[result.Host] = append(open[result.Host], info.Port)
+		}
+	}
+	if err := m.Err(); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	if got := open["10.0.0.1"]; len(got) != 2 || got[0] != 22 || got[1] != 80 {
+		t.Errorf("10.0.0.1 open ports = %v, want [22 80]", got)
+	}
+	if got := open["10.0.0.2"]; len(got) != 1 || got[0] != 22 {
+		t.Errorf("10.0.0.2 open ports = %v, want [22]", got)
+	}
+	if len(down) != 1 || down[0] != "10.9.9.9" {
+		t.Errorf("down hosts = %v, want [10.9.9.9]", down)
+	}
+}
+
+func TestSimMultiScannerPortOrder(t *testing.T) {
+	n := NewSimNetwork()
+	for _, port := range []int{53, 22, 161} {
+		n.SetPort("10.0.0.1", ProtoUDP, port, SimPort{State: SimOpen})
+		n.Open("10.0.0.1", port, "")
+	}
+
+	want := []PortTarget{tcp(22), udp(22), tcp(53), udp(53), tcp(161), udp(161)}
+	for run := 0; run < 5; run++ {
+		options := append(simOptions(n, 100*time.Millisecond), WithPorts(mustPorts(t, "22,53,161,U:22,53,161")), WithRandomOrder(int64(run)))
+		m := NewMultiScanner(hostList([]string{"10.0.0.1"}), options...)
+		for result := range m.Results() {
+			got := []PortTarget{}
+			for _, info := range result.Ports {
+				got = append(got, PortTarget{Port: info.Port, Proto: info.Proto})
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("run %d: open ports in order %v, want %v", run, got, want)
+			}
+		}
+	}
+}
+
+func TestSimRetries(t *testing.T) {
+	n := newTestNetwork()
+	options := append(simOptions(n, 20*time.Millisecond), WithRetries(2, time.Millisecond))
+	results, s := simScan(t, "10.0.0.1", "22,443", options...)
+
+	if got := results[PortTarget{443, ProtoTCP}].Attempts; got != 3 {
+		t.Errorf("filtered port took %d attempts, want 3", got)
+	}
+	if got := results[PortTarget{22, ProtoTCP}].Attempts; got != 1 {
+		t.Errorf("open port took %d attempts, want 1", got)
+	}
+	if got := n.Dials(); got != 4 {
+		t.Errorf("%d dials, want 4", got)
+	}
+	if got := s.Stats().Retries; got != 2 {
+		t.Errorf("Stats().Retries = %d, want 2", got)
+	}
+}
+
+func TestSimBanners(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		n := newTestNetwork()
+		options := append(simOptions(n, 100*time.Millisecond), WithBanners(2, 100*time.Millisecond))
+		if reuse {
+			options = append(options, WithConnReuse())
+		}
+		results, _ := simScan(t, "10.0.0.1", "21-23", options...)
+
+		if banner := results[PortTarget{22, ProtoTCP}].Banner; !strings.HasPrefix(banner, "SSH-2.0-Sim") {
+			t.Errorf("reuse=%v: banner on 22 = %q, want the SSH greeting", reuse, banner)
+		}
+
+		// Reusing the probe connection saves the second dial to the open port
+		want := 4
+		if reuse {
+			want = 3
+		}
+		if got := n.Dials(); got != want {
+			t.Errorf("reuse=%v: %d dials, want %d", reuse, got, want)
+		}
+	}
+}
+
+func TestSimCheckpointSaved(t *testing.T) {
+	n := newTestNetwork()
+	path := filepath.Join(t.TempDir(), "scan.json")
+	options := append(simOptions(n, 50*time.Millisecond), WithCheckpoint(path, "scan", "10.0.0.1"))
+	simScan(t, "10.0.0.1", "1-100", options...)
+
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.Complete || cp.Ports != "1-100" || len(cp.Hosts) != 1 {
+		t.Fatalf("checkpoint = %+v, want one complete host scanned on 1-100", cp)
+	}
+	if open := cp.Hosts[0].Open; len(open) != 2 || open[0].Port != 22 || open[1].Port != 80 {
+		t.Errorf("checkpoint open ports = %+v, want 22 and 80", open)
+	}
+}
+
+func TestSimCheckpointResume(t *testing.T) {
+	n := newTestNetwork()
+	cp := &Checkpoint{
+		Version: checkpointVersion,
+		Ports:   "1-100",
+		Hosts: []HostCheckpoint{{
+			Name:    "10.0.0.1",
+			Address: "10.0.0.1",
+			Probed:  "1-50",
+			Open:    []PortInfo{{Port: 22, Proto: ProtoTCP, Service: "SSH"}},
+		}},
+	}
+
+	options := append(simOptions(n, 50*time.Millisecond), WithResume(cp))
+	results, _ := simScan(t, "10.0.0.1", "1-100", options...)
+
+	if got := n.Dials(); got != 50 {
+		t.Errorf("resumed scan dialled %d times, want 50 for the ports left", got)
+	}
+	for _, port := range []int{22, 80} {
+		if got := results[PortTarget{port, ProtoTCP}].Status; got != StatusOpen {
+			t.Errorf("%d is %v after resuming, want open", port, got)
+		}
+	}
+
+	// Skipping ports only works for the same port list
+	s := NewScanner(append(simOptions(n, 50*time.Millisecond), WithTarget("10.0.0.1"), WithPortRange(1, 200), WithResume(cp))...)
+	if _, err := s.ScanDetailed(); err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Errorf("resume with other ports returned %v, want a checkpoint error", err)
+	}
+}
+
+func TestSimMultiScannerResume(t *testing.T) {
+	n := newTestNetwork()
+	cp := &Checkpoint{
+		Version: checkpointVersion,
+		Ports:   "22,80",
+		Hosts: []HostCheckpoint{{
+			Name:     "10.0.0.2",
+			Address:  "10.0.0.2",
+			Open:     []PortInfo{{Port: 22, Proto: ProtoTCP, Service: "SSH"}},
+			Finished: true,
+		}},
+	}
+
+	options := append(simOptions(n, 50*time.Millisecond), WithPorts(mustPorts(t, "22,80")), WithResume(cp))
+	m := NewMultiScanner(hostList([]string{"10.0.0.1", "10.0.0.2"}), options...)
+	for result := range m.Results() {
+		if result.Host == "10.0.0.2" && (!result.Resumed || len(result.Ports) != 1) {
+			t.Errorf("finished host came back as %+v, want the saved result", result)
+		}
+	}
+	if got := n.Dials(); got != 2 {
+		t.Errorf("%d dials, want 2 for the host that wasn't finished", got)
+	}
+}
+
+// Fails every dial with errno, like a system that won't let us connect
+type errnoDialer syscall.Errno
+
+func (d errnoDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, &net.OpError{Op: "dial", Net: network, Err: os.NewSyscallError("connect", syscall.Errno(d))}
+}
+
+func TestSimFailureSummary(t *testing.T) {
+	dialer := errnoDialer(syscall.EACCES)
+
+	_, s := simScan(t, "10.0.0.1", "1-10", simOptions(dialer, 50*time.Millisecond)...)
+	if failure := s.Stats().Failure(); !errors.Is(failure, ErrPermissionDenied) {
+		t.Errorf("Failure() = %v, want ErrPermissionDenied", failure)
+	}
+
+	options := append(simOptions(dialer, 50*time.Millisecond), WithPortRange(1, 10))
+	m := NewMultiScanner(hostList([]string{"10.0.0.1"}), options...)
+	for result := range m.Results() {
+		if !errors.Is(result.Err, ErrPermissionDenied) {
+			t.Errorf("host error = %v, want ErrPermissionDenied", result.Err)
+		}
+		if failures := result.Stats.Failures; len(failures) != 1 || failures[0].Count != 10 {
+			t.Errorf("failures = %+v, want all 10 probes denied", failures)
+		}
+	}
+
+	// One answer is enough for the scan to count
+	n := newTestNetwork()
+	_, s = simScan(t, "10.0.0.1", "22,443", simOptions(n, 20*time.Millisecond)...)
+	if failure := s.Stats().Failure(); failure != nil {
+		t.Errorf("Failure() = %v for a scan that found an open port", failure)
+	}
+}
+
+func TestSimNamesLeftToDialer(t *testing.T) {
+	n := NewSimNetwork()
+	n.Open("internal.example", 22, "")
+
+	options := append(simOptions(n, 50*time.Millisecond), WithPorts(mustPorts(t, "22")))
+	m := NewMultiScanner(hostList([]string{"internal.example"}), options...)
+	for result := range m.Results() {
+		if len(result.Ports) != 1 || len(result.Addresses) != 0 {
+			t.Errorf("result = %+v, want port 22 open and no addresses", result)
+		}
+	}
+
+	// The dialer resolves names, so nothing may go to the local resolver
+	dns := m.scanner.dns
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	if len(dns.forward) != 0 {
+		t.Errorf("%d names resolved locally, want none", len(dns.forward))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+)
+
+// Where outgoing connections leave from. A nil binding lets the OS choose,
+// just like a zero-value net.Dialer.
+type sourceBinding struct {
+	v4, v6   netip.Addr // Local address for each family, if any
+	portMin  int        // Source port range, 0 for any port
+	portMax  int
+	nextPort atomic.Uint32
+}
+
+// Builds a binding from a source address or an interface name, plus an
+// optional source port range. Returns nil when nothing needs binding.
+func newSourceBinding(address, iface string, portMin, portMax int) (*sourceBinding, error) {
+	if address == "" && iface == "" && portMin == 0 {
+		return nil, nil
+	}
+	if address != "" && iface != "" {
+		return nil, fmt.Errorf("use either a source address or an interface, not both")
+	}
+	if portMin < 0 || portMax > 65535 || portMax < portMin {
+		return nil, fmt.Errorf("invalid source port range %d-%d", portMin, portMax)
+	}
+
+	b := &sourceBinding{portMin: portMin, portMax: portMax}
+
+	switch {
+	case address != "":
+		addr, err := netip.ParseAddr(normalizeHost(address))
+		if err != nil {
+			return nil, fmt.Errorf("invalid source address %q", address)
+		}
+		if addr = addr.Unmap(); addr.Is4() {
+			b.v4 = addr
+		} else {
+			b.v6 = addr
+		}
+
+	case iface != "":
+		if err := b.useInterface(iface); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// Picks the interface's first IPv4 and first global IPv6 address
+func (b *sourceBinding) useInterface(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", name, err)
+	}
+
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		addr := prefix.Addr().Unmap()
+
+		switch {
+		case addr.Is4() && !b.v4.IsValid():
+			b.v4 = addr
+		case addr.Is6() && !addr.IsLinkLocalUnicast() && !b.v6.IsValid():
+			b.v6 = addr
+		}
+	}
+
+	if !b.v4.IsValid() && !b.v6.IsValid() {
+		return fmt.Errorf("interface %s has no usable addresses", name)
+	}
+	return nil
+}
+
+// Local address to use when talking to host, invalid if the OS may choose
+func (b *sourceBinding) localAddr(host string) (netip.Addr, error) {
+	if !b.v4.IsValid() && !b.v6.IsValid() {
+		return netip.Addr{}, nil
+	}
+
+	// Hostnames go over IPv4 when we can, the dialer skips records of the other family
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		if b.v4.IsValid() {
+			return b.v4, nil
+		}
+		return b.v6, nil
+	}
+
+	if remote.Unmap().Is4() {
+		if !b.v4.IsValid() {
+			return netip.Addr{}, fmt.Errorf("no IPv4 source address to reach %s", host)
+		}
+		return b.v4, nil
+	}
+	if !b.v6.IsValid() {
+		return netip.Addr{}, fmt.Errorf("no IPv6 source address to reach %s", host)
+	}
+	return b.v6, nil
+}
+
+// Next source port from the range, 0 if there's no range
+func (b *sourceBinding) port() int {
+	if b.portMin == 0 {
+		return 0
+	}
+	span := uint32(b.portMax - b.portMin + 1)
+	return b.portMin + int(b.nextPort.Add(1)%span)
+}
+
+// Dials address from the bound source. Ports still in use from an earlier
+// connection are skipped for the next one in the range, and reported as
+// ErrSourcePortInUse once the attempts run out.
+func (b *sourceBinding) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if b == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	local, err := b.localAddr(host)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := 1
+	if b.portMin != 0 {
+		attempts = min(b.portMax-b.portMin+1, 10)
+	}
+
+	for attempt := 1; ; attempt++ {
+		var d net.Dialer
+		port := b.port()
+		if local.IsValid() || port != 0 {
+			ip := net.IP(nil)
+			if local.IsValid() {
+				ip = local.AsSlice()
+			}
+			if network == "udp" || network == "udp4" || network == "udp6" {
+				d.LocalAddr = &net.UDPAddr{IP: ip, Port: port}
+			} else {
+				d.LocalAddr = &net.TCPAddr{IP: ip, Port: port}
+			}
+		}
+		if port != 0 {
+			d.Control = reuseSourcePort
+		}
+
+		conn, err := d.DialContext(ctx, network, address)
+		if err == nil || attempt >= attempts || ctx.Err() != nil {
+			return conn, err
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return conn, err
+		}
+	}
+}
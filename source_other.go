@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// SO_REUSEADDR means something else outside Unix, so source ports are
+// left as they are
+func reuseSourcePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
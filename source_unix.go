@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Lets a source port be bound again while an earlier connection from it
+// lingers in TIME_WAIT or still talks to another port
+func reuseSourcePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
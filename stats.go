@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Smallest latency histogram bucket, each one after it is twice as wide
+const latencyBucketBase = 100 * time.Microsecond
+
+// Enough doublings to reach past a minute
+const latencyBucketCount = 21
+
+// Tallies probe outcomes and connect times for ScanStats
+type statsRecorder struct {
+	mu       sync.Mutex
+	probed   int
+	statuses map[PortStatus]int
+	errors   map[string]int
+	failures []FailureCount // In the order each kind first showed up
+	retries  int
+
+	// Connect times of probes that got an answer
+	samples int
+	total   time.Duration
+	min     time.Duration
+	max     time.Duration
+	buckets [latencyBucketCount]int
+}
+
+// Creates an empty recorder
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{
+		statuses: make(map[PortStatus]int),
+		errors:   make(map[string]int),
+	}
+}
+
+// Forgets everything, ready for a new run
+func (r *statsRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.probed, r.retries = 0, 0
+	r.statuses = make(map[PortStatus]int)
+	r.errors = make(map[string]int)
+	r.failures = nil
+	r.samples, r.total, r.min, r.max = 0, 0, 0, 0
+	r.buckets = [latencyBucketCount]int{}
+}
+
+// Adds one probe's outcome
+func (r *statsRecorder) add(result PortResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.probed++
+	r.statuses[result.Status]++
+	if result.Attempts > 1 {
+		r.retries += result.Attempts - 1
+	}
+
+	// Why the port wasn't open, by the reason the probe gave
+	var scanErr *ScanError
+	if errors.As(result.Err, &scanErr) {
+		r.errors[scanErr.Message]++
+		if scanErr.Kind != nil {
+			r.addFailure(scanErr)
+		}
+	}
+
+	if result.RTT > 0 {
+		r.samples++
+		r.total += result.RTT
+		if r.min == 0 || result.RTT < r.min {
+			r.min = result.RTT
+		}
+		if result.RTT > r.max {
+			r.max = result.RTT
+		}
+		r.buckets[latencyBucket(result.RTT)]++
+	}
+}
+
+// Counts a probe that failed with one of the Err* kinds, called with the lock held
+func (r *statsRecorder) addFailure(scanErr *ScanError) {
+	for i := range r.failures {
+		if r.failures[i].Kind == scanErr.Kind {
+			r.failures[i].Count++
+			return
+		}
+	}
+	r.failures = append(r.failures, FailureCount{Kind: scanErr.Kind, Count: 1, First: scanErr})
+}
+
+// Histogram bucket a connect time falls in
+func latencyBucket(d time.Duration) int {
+	i := 0
+	for limit := latencyBucketBase; d >= limit && i < latencyBucketCount-1; limit *= 2 {
+		i++
+	}
+	return i
+}
+
+// Summary of everything recorded so far, over elapsed wall time
+func (r *statsRecorder) snapshot(elapsed time.Duration) ScanStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := ScanStats{
+		Probed:   r.probed,
+		Statuses: make(map[PortStatus]int, len(r.statuses)),
+		Errors:   make(map[string]int, len(r.errors)),
+		Retries:  r.retries,
+		Elapsed:  elapsed,
+	}
+	for status, count := range r.statuses {
+		stats.Statuses[status] = count
+	}
+	for message, count := range r.errors {
+		stats.Errors[message] = count
+	}
+	stats.Failures = append(stats.Failures, r.failures...)
+	sort.SliceStable(stats.Failures, func(i, j int) bool {
+		return stats.Failures[i].Count > stats.Failures[j].Count
+	})
+	if elapsed > 0 {
+		stats.Rate = float64(r.probed) / elapsed.Seconds()
+	}
+	if r.samples == 0 {
+		return stats
+	}
+
+	stats.Latency = LatencyStats{
+		Samples: r.samples,
+		Min:     r.min,
+		Avg:     r.total / time.Duration(r.samples),
+		Max:     r.max,
+	}
+
+	// Buckets only know their bounds, so p95 is the bound of the bucket it falls in
+	wanted := (r.samples*95 + 99) / 100
+	seen := 0
+	limit := latencyBucketBase
+	for _, count := range r.buckets {
+		seen += count
+		if count > 0 {
+			stats.Latency.Buckets = append(stats.Latency.Buckets, LatencyBucket{Below: limit, Count: count})
+		}
+		if stats.Latency.P95 == 0 && seen >= wanted {
+			stats.Latency.P95 = limit
+			if stats.Latency.P95 > r.max {
+				stats.Latency.P95 = r.max
+			}
+		}
+		limit *= 2
+	}
+
+	return stats
+}
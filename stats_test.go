@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Records count answered probes that took rtt each
+func addAnswered(r *statsRecorder, count int, rtt time.Duration) {
+	for i := 0; i < count; i++ {
+		r.add(PortResult{Status: StatusOpen, Attempts: 1, RTT: rtt})
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{99 * time.Microsecond, 0},
+		{100 * time.Microsecond, 1},
+		{199 * time.Microsecond, 1},
+		{200 * time.Microsecond, 2},
+		{50 * time.Millisecond, 9},
+		{time.Hour, latencyBucketCount - 1},
+	}
+	for _, tt := range tests {
+		if got := latencyBucket(tt.d); got != tt.want {
+			t.Errorf("latencyBucket(%s) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestStatsLatency(t *testing.T) {
+	r := newStatsRecorder()
+	addAnswered(r, 95, 150*time.Microsecond)
+	addAnswered(r, 5, 50*time.Millisecond)
+
+	latency := r.snapshot(time.Second).Latency
+	if latency.Samples != 100 || latency.Min != 150*time.Microsecond || latency.Max != 50*time.Millisecond {
+		t.Errorf("latency = %+v, want 100 samples from 150µs to 50ms", latency)
+	}
+	if latency.P95 != 200*time.Microsecond {
+		t.Errorf("p95 = %s, want the 200µs bound holding 95 samples", latency.P95)
+	}
+	want := []LatencyBucket{{Below: 200 * time.Microsecond, Count: 95}, {Below: 51200 * time.Microsecond, Count: 5}}
+	if len(latency.Buckets) != len(want) || latency.Buckets[0] != want[0] || latency.Buckets[1] != want[1] {
+		t.Errorf("buckets = %+v, want %+v", latency.Buckets, want)
+	}
+
+	// A p95 in the last bucket is capped at the slowest sample seen
+	addAnswered(r, 10, 50*time.Millisecond)
+	if p95 := r.snapshot(time.Second).Latency.P95; p95 != 50*time.Millisecond {
+		t.Errorf("p95 = %s, want the 50ms maximum", p95)
+	}
+}
+
+func TestStatsOutcomes(t *testing.T) {
+	r := newStatsRecorder()
+	denied := &ScanError{Message: "permission denied", Kind: ErrPermissionDenied}
+	exhausted := &ScanError{Message: "too many open files", Kind: ErrTooManyOpenFiles}
+	r.add(PortResult{Status: StatusError, Attempts: 1, Err: exhausted})
+	r.add(PortResult{Status: StatusError, Attempts: 1, Err: denied})
+	r.add(PortResult{Status: StatusError, Attempts: 1, Err: denied})
+	r.add(PortResult{Status: StatusFiltered, Attempts: 3, Err: &ScanError{Message: "timed out"}})
+
+	stats := r.snapshot(2 * time.Second)
+	if stats.Probed != 4 || stats.Retries != 2 || stats.Rate != 2 {
+		t.Errorf("probed %d, retries %d, rate %v, want 4, 2 and 2/s", stats.Probed, stats.Retries, stats.Rate)
+	}
+	if stats.Statuses[StatusError] != 3 || stats.Errors["permission denied"] != 2 || stats.Errors["timed out"] != 1 {
+		t.Errorf("statuses %v and errors %v don't match the probes", stats.Statuses, stats.Errors)
+	}
+	if len(stats.Failures) != 2 || stats.Failures[0].Kind != ErrPermissionDenied || stats.Failures[0].Count != 2 {
+		t.Errorf("failures = %+v, want permission denied first with 2", stats.Failures)
+	}
+
+	// The timeout answered nothing but isn't a failure, so the scan still counts
+	if failure := stats.Failure(); failure != nil {
+		t.Errorf("Failure() = %v, want nil while a probe didn't fail", failure)
+	}
+
+	r.reset()
+	r.add(PortResult{Status: StatusError, Attempts: 1, Err: denied})
+	if failure := r.snapshot(time.Second).Failure(); !errors.Is(failure, ErrPermissionDenied) {
+		t.Errorf("Failure() = %v, want ErrPermissionDenied", failure)
+	}
+}
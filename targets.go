@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Largest single range or prefix we accept, enough for a /8
+const maxTargetRangeSize = 1 << 24
+
+// Parsed target specification. Hosts are generated lazily, so a /8 costs
+// a couple of addresses of memory rather than millions of strings.
+type TargetSpec struct {
+	items         []targetItem
+	excludes      []addrRange
+	excludedNames map[string]bool
+}
+
+// One entry of a spec: either a hostname or an inclusive address range
+type targetItem struct {
+	name  string
+	addrs addrRange
+}
+
+// Inclusive range of addresses from the same family
+type addrRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+// Reports whether addr falls inside the range
+func (r addrRange) contains(addr netip.Addr) bool {
+	return r.first.Compare(addr) <= 0 && addr.Compare(r.last) <= 0
+}
+
+// Number of addresses in the range, capped at the largest uint64
+func (r addrRange) size() uint64 {
+	first := new(big.Int).SetBytes(r.first.AsSlice())
+	last := new(big.Int).SetBytes(r.last.AsSlice())
+	size := new(big.Int).Sub(last, first)
+	size.Add(size, big.NewInt(1))
+	if !size.IsUint64() {
+		return math.MaxUint64
+	}
+	return size.Uint64()
+}
+
+// Address offset places after the first, which must still be in the range
+func (r addrRange) at(offset uint64) netip.Addr {
+	n := new(big.Int).SetBytes(r.first.AsSlice())
+	n.Add(n, new(big.Int).SetUint64(offset))
+	bytes := make([]byte, r.first.BitLen()/8)
+	n.FillBytes(bytes)
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// Parses target specs such as "10.0.0.0/16, 192.168.1.1-192.168.2.50, example.com, @hosts.txt".
+// Items prefixed with "!" are excluded, as is everything matched by the exclude spec.
+func parseTargetSpec(spec, exclude string) (*TargetSpec, error) {
+	t := &TargetSpec{excludedNames: make(map[string]bool)}
+
+	if err := t.add(spec, false, 0); err != nil {
+		return nil, err
+	}
+	if err := t.add(exclude, true, 0); err != nil {
+		return nil, err
+	}
+
+	if len(t.items) == 0 {
+		return nil, fmt.Errorf("no targets given")
+	}
+
+	return t, nil
+}
+
+// Splits a comma or whitespace separated list of targets
+func splitTargetSpec(spec string) []string {
+	return strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// Reports whether a spec reads targets from files
+func usesTargetFiles(spec string) bool {
+	for _, item := range splitTargetSpec(spec) {
+		if _, isFile := targetFilePath(strings.TrimPrefix(item, "!")); isFile {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds every item in a comma or whitespace separated list
+func (t *TargetSpec) add(spec string, exclude bool, depth int) error {
+	for _, item := range splitTargetSpec(spec) {
+		excluded := exclude
+		if strings.HasPrefix(item, "!") {
+			excluded = true
+			item = item[1:]
+		}
+
+		// Target files, one or more items per line
+		if path, isFile := targetFilePath(item); isFile {
+			if depth > 0 {
+				return fmt.Errorf("target file %s: files can't include other files", path)
+			}
+			if err := t.addFile(path, excluded, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		parsed, err := parseTargetItem(item)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case excluded && parsed.name != "":
+			t.excludedNames[strings.ToLower(parsed.name)] = true
+		case excluded:
+			t.excludes = append(t.excludes, parsed.addrs)
+		default:
+			t.items = append(t.items, parsed)
+		}
+	}
+
+	return nil
+}
+
+// Recognises "@hosts.txt" and "file:hosts.txt"
+func targetFilePath(item string) (string, bool) {
+	if strings.HasPrefix(item, "@") {
+		return item[1:], true
+	}
+	if strings.HasPrefix(strings.ToLower(item), "file:") {
+		return item[5:], true
+	}
+	return "", false
+}
+
+// Reads targets from a file, ignoring blank lines and # comments
+func (t *TargetSpec) addFile(path string, exclude bool, depth int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening target file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if err := t.add(line, exclude, depth); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Parses a single CIDR block, address range, IP or hostname
+func parseTargetItem(item string) (targetItem, error) {
+	if item == "" {
+		return targetItem{}, fmt.Errorf("empty target")
+	}
+
+	// CIDR block
+	if strings.Contains(item, "/") {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return targetItem{}, fmt.Errorf("invalid CIDR block %q", item)
+		}
+		prefix = prefix.Masked()
+		return rangeItem(item, prefix.Addr(), lastAddr(prefix))
+	}
+
+	// Address range, only when the left side is an IP since hostnames can contain dashes
+	if left, right, found := strings.Cut(item, "-"); found {
+		if start, err := netip.ParseAddr(normalizeHost(left)); err == nil {
+			end, err := parseRangeEnd(start, normalizeHost(right))
+			if err != nil {
+				return targetItem{}, fmt.Errorf("invalid range %q: %w", item, err)
+			}
+			return rangeItem(item, start, end)
+		}
+	}
+
+	// Single address
+	item = normalizeHost(item)
+	if addr, err := netip.ParseAddr(item); err == nil {
+		return targetItem{addrs: addrRange{first: addr, last: addr}}, nil
+	}
+
+	// Anything else is treated as a hostname
+	if strings.ContainsAny(item, " /\\") {
+		return targetItem{}, fmt.Errorf("invalid target %q", item)
+	}
+	return targetItem{name: item}, nil
+}
+
+// Parses the end of a range, either a full address or just the last IPv4 octet
+func parseRangeEnd(start netip.Addr, end string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(end); err == nil {
+		return addr, nil
+	}
+
+	// Short form 192.168.1.10-20
+	octet, err := strconv.Atoi(end)
+	if err != nil || !start.Is4() || octet < 0 || octet > 255 {
+		return netip.Addr{}, fmt.Errorf("invalid end address %q", end)
+	}
+	bytes := start.As4()
+	bytes[3] = byte(octet)
+	return netip.AddrFrom4(bytes), nil
+}
+
+// Validates and builds a range item
+func rangeItem(item string, start, end netip.Addr) (targetItem, error) {
+	if start.Is4() != end.Is4() {
+		return targetItem{}, fmt.Errorf("range %q mixes IPv4 and IPv6", item)
+	}
+	if end.Less(start) {
+		return targetItem{}, fmt.Errorf("range %q: start must be less than or equal to end", item)
+	}
+
+	r := addrRange{first: start, last: end}
+	if r.size() > maxTargetRangeSize {
+		return targetItem{}, fmt.Errorf("range %q is too large (max %d addresses)", item, maxTargetRangeSize)
+	}
+
+	return targetItem{addrs: r}, nil
+}
+
+// Last address inside a prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// Number of hosts the spec covers before exclusions
+func (t *TargetSpec) Count() uint64 {
+	var count uint64
+	for _, item := range t.items {
+		if item.name != "" {
+			count++
+		} else {
+			count += item.addrs.size()
+		}
+	}
+	return count
+}
+
+// Reports whether a host is excluded
+func (t *TargetSpec) excluded(host string, addr netip.Addr) bool {
+	if t.excludedNames[strings.ToLower(host)] {
+		return true
+	}
+	if !addr.IsValid() {
+		return false
+	}
+	for _, r := range t.excludes {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fresh lazy iterator over every host in the spec, skipping exclusions
+func (t *TargetSpec) Hosts() HostSource {
+	index := 0
+	var current netip.Addr // Next address within the current range
+
+	return func() (string, bool) {
+		for index < len(t.items) {
+			item := t.items[index]
+
+			// Hostnames are a single step
+			if item.name != "" {
+				index++
+				if t.excluded(item.name, netip.Addr{}) {
+					continue
+				}
+				return item.name, true
+			}
+
+			// Walk the range one address at a time
+			if !current.IsValid() {
+				current = item.addrs.first
+			}
+			addr := current
+			if addr == item.addrs.last {
+				index++
+				current = netip.Addr{}
+			} else {
+				current = addr.Next()
+			}
+
+			if t.excluded(addr.String(), addr) {
+				continue
+			}
+			return addr.String(), true
+		}
+
+		return "", false
+	}
+}
+
+// Fresh lazy iterator over every host in the spec in a seeded random order,
+// so a shuffled scan doesn't work through one subnet after another
+func (t *TargetSpec) ShuffledHosts(seed int64) HostSource {
+	// Where each item starts when the hosts are numbered in spec order
+	starts := make([]uint64, len(t.items))
+	var count uint64
+	for i, item := range t.items {
+		starts[i] = count
+		if item.name != "" {
+			count++
+		} else {
+			count += item.addrs.size()
+		}
+	}
+	order := newPermutation(count, seed)
+
+	return func() (string, bool) {
+		for {
+			index, ok := order.Next()
+			if !ok {
+				return "", false
+			}
+
+			i := sort.Search(len(starts), func(i int) bool { return starts[i] > index }) - 1
+			item := t.items[i]
+			if item.name != "" {
+				if t.excluded(item.name, netip.Addr{}) {
+					continue
+				}
+				return item.name, true
+			}
+
+			addr := item.addrs.at(index - starts[i])
+			if t.excluded(addr.String(), addr) {
+				continue
+			}
+			return addr.String(), true
+		}
+	}
+}
+
+// Every host in the spec as a slice, for small specs
+func (t *TargetSpec) List() []string {
+	hosts := []string{}
+	next := t.Hosts()
+	for host, ok := next(); ok; host, ok = next() {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
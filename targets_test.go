@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Drains a host source
+func collectHosts(next HostSource) []string {
+	hosts := []string{}
+	for host, ok := next(); ok; host, ok = next() {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+func TestShuffledHosts(t *testing.T) {
+	spec, err := parseTargetSpec("10.0.0.0/28, db.example, 10.0.1.250-10.0.2.5, 2001:db8::/126", "10.0.0.3, db.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := collectHosts(spec.Hosts())
+	got := collectHosts(spec.ShuffledHosts(42))
+	if slices.Equal(got, want) {
+		t.Errorf("ShuffledHosts(42) kept spec order: %v", got)
+	}
+	if !slices.Equal(collectHosts(spec.ShuffledHosts(42)), got) {
+		t.Error("ShuffledHosts(42) gave a different order the second time")
+	}
+
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("ShuffledHosts(42) = %v, want the hosts %v", got, want)
+	}
+}
+
+func TestParseTargetSpec(t *testing.T) {
+	tests := []struct {
+		spec, exclude string
+		want          []string
+	}{
+		{"10.0.0.1", "", []string{"10.0.0.1"}},
+		{"10.0.0.0/30", "", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"10.0.0.5/30", "", []string{"10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}},
+		{"10.0.0.254-10.0.1.1", "", []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+		{"192.168.1.10-12", "", []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"}},
+		{"host-1.example, web.example", "", []string{"host-1.example", "web.example"}},
+		{"a.example b.example\tc.example", "", []string{"a.example", "b.example", "c.example"}},
+		{"2001:db8::/126", "", []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{"[2001:db8::1]", "", []string{"2001:db8::1"}},
+		{"2001:db8::fe-2001:db8::101", "", []string{"2001:db8::fe", "2001:db8::ff", "2001:db8::100", "2001:db8::101"}},
+		{"10.0.0.0/30, !10.0.0.2", "", []string{"10.0.0.0", "10.0.0.1", "10.0.0.3"}},
+		{"10.0.0.0/29", "10.0.0.2-10.0.0.5", []string{"10.0.0.0", "10.0.0.1", "10.0.0.6", "10.0.0.7"}},
+		{"a.example, B.example", "b.EXAMPLE", []string{"a.example"}},
+	}
+	for _, tt := range tests {
+		spec, err := parseTargetSpec(tt.spec, tt.exclude)
+		if err != nil {
+			t.Errorf("parseTargetSpec(%q, %q) failed: %v", tt.spec, tt.exclude, err)
+			continue
+		}
+		if got := collectHosts(spec.Hosts()); !slices.Equal(got, tt.want) {
+			t.Errorf("parseTargetSpec(%q, %q) gives %v, want %v", tt.spec, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestParseTargetSpecErrors(t *testing.T) {
+	tests := []struct{ spec, exclude string }{
+		{"", ""},
+		{" , ", ""},
+		{"!10.0.0.1", ""},
+		{"10.0.0.0/33", ""},
+		{"10.0.0.5-10.0.0.1", ""},
+		{"10.0.0.1-256", ""},
+		{"10.0.0.1-2001:db8::1", ""},
+		{"10.0.0.0/7", ""},
+		{"bad\\host", ""},
+		{"10.0.0.1", "10.0.0.0/40"},
+		{"@" + filepath.Join(t.TempDir(), "missing.txt"), ""},
+	}
+	for _, tt := range tests {
+		if spec, err := parseTargetSpec(tt.spec, tt.exclude); err == nil {
+			t.Errorf("parseTargetSpec(%q, %q) = %v, want an error", tt.spec, tt.exclude, spec.List())
+		}
+	}
+}
+
+func TestParseTargetFile(t *testing.T) {
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "hosts.txt")
+	skip := filepath.Join(dir, "skip.txt")
+	nested := filepath.Join(dir, "nested.txt")
+	writeFile(t, hosts, "# Lab network\n10.0.0.0/30\n\ndb.example, cache.example  # both replicas\n")
+	writeFile(t, skip, "10.0.0.1\ncache.example\n")
+	writeFile(t, nested, "@"+hosts+"\n")
+
+	spec, err := parseTargetSpec("@"+hosts+" file:"+filepath.Join(dir, "hosts.txt"), "@"+skip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both spellings of the file are read, comments and exclusions skipped
+	want := []string{"10.0.0.0", "10.0.0.2", "10.0.0.3", "db.example"}
+	if got := collectHosts(spec.Hosts()); !slices.Equal(got, append(want, want...)) {
+		t.Errorf("hosts from file = %v, want %v twice", got, want)
+	}
+	if !usesTargetFiles("10.0.0.1, !@" + skip) {
+		t.Error("usesTargetFiles missed an excluded file")
+	}
+
+	if _, err := parseTargetSpec("@"+nested, ""); err == nil {
+		t.Error("a target file including another file was accepted")
+	}
+}
+
+// Writes a file the test needs
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,42 +1,172 @@
-package main
-
-import (
-	"sync"
-	"time"
-)
-
-// Stores scan results
-type ScanResult struct {
-	Host      string
-	Ports     []PortInfo
-	Timestamp time.Time
-	Duration  time.Duration
-}
-
-// Info about an open port
-type PortInfo struct {
-	Port    int
-	Service string
-	Banner  string
-}
-
-// Thread-safe global results storage
-var (
-	scanResults  []ScanResult
-	resultsMutex sync.RWMutex
-)
-
-// Possible port states
-type PortStatus int
-
-const (
-	StatusOpen PortStatus = iota
-	StatusClosed
-	StatusFiltered
-	StatusError
-)
-
-// Convert status to string
-func (s PortStatus) String() string {
-	return [...]string{"Open", "Closed", "Filtered", "Error"}[s]
-}
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Stores scan results
+type ScanResult struct {
+	Host      string
+	Address   string   // IP that was scanned, if known
+	Family    string   // "IPv4" or "IPv6", empty if the resolver picked
+	Addresses []string // Everything Host resolved to
+	Names     []string // Reverse DNS names for an IP target
+	Ports     []PortInfo
+	Timestamp time.Time
+	Duration  time.Duration
+	RTT       RTTStats
+	Stats     ScanStats
+	Down      bool  // Failed the liveness check and wasn't scanned
+	Resumed   bool  // Finished by an earlier run, restored from a checkpoint
+	Err       error // Why the host couldn't be scanned at all
+}
+
+// Connect round-trip times measured for a host
+type RTTStats struct {
+	Samples int
+	SRTT    time.Duration // Smoothed average
+	RTTVar  time.Duration // Smoothed variation
+	Min     time.Duration
+	Max     time.Duration
+	Timeout time.Duration // Probe timeout in use at the end of the scan
+}
+
+// What a scan did, for one host or the whole run
+type ScanStats struct {
+	Probed   int
+	Statuses map[PortStatus]int // Ports per outcome
+	Errors   map[string]int     // Why ports weren't open, e.g. "timed out": 12
+	Failures []FailureCount     // Probes that hit one of the Err* kinds, most common first
+	Retries  int                // Extra attempts on timed-out ports
+	Latency  LatencyStats       // Connect times of ports that answered
+	Rate     float64            // Probes per second
+	Elapsed  time.Duration      // Wall time
+
+	// Whole scan only, zero in per-host stats
+	Threads         int    // Workers started, the most that can probe at once
+	AutoThreads     bool   // Concurrency was tuned to the network as the scan went
+	Concurrency     int    // Probes allowed at once by the end, fewer when short of file descriptors
+	PeakConcurrency int    // Most probes allowed at once at any point
+	FileLimit       uint64 // Soft limit on open files, 0 if unknown
+	Requeued        int    // Probes tried again after the system ran out of sockets
+}
+
+// How many probes failed one way
+type FailureCount struct {
+	Kind  error // One of the Err* kinds
+	Count int
+	First error // Earliest example, naming the host and port
+}
+
+// Error explaining why no probe got an answer, nil if any did
+func (s ScanStats) Failure() *ScanError {
+	failed := 0
+	for _, failure := range s.Failures {
+		failed += failure.Count
+	}
+	if s.Probed == 0 || failed < s.Probed {
+		return nil
+	}
+	return &ScanError{Message: "no probe got through", Kind: s.Failures[0].Kind, Err: s.Failures[0].Kind}
+}
+
+// Distribution of connect times
+type LatencyStats struct {
+	Samples int
+	Min     time.Duration
+	Avg     time.Duration
+	P95     time.Duration // Upper bound of the histogram bucket it falls in
+	Max     time.Duration
+	Buckets []LatencyBucket // Histogram, empty buckets left out
+}
+
+// Connect times up to a bound
+type LatencyBucket struct {
+	Below time.Duration
+	Count int
+}
+
+// Info about an open port
+type PortInfo struct {
+	Port    int
+	Proto   Protocol
+	Service string
+	Banner  string
+}
+
+// Thread-safe global results storage
+var (
+	scanResults  []ScanResult
+	resultsMutex sync.RWMutex
+)
+
+// Possible port states
+type PortStatus int
+
+const (
+	StatusOpen PortStatus = iota
+	StatusClosed
+	StatusFiltered
+	StatusError
+	StatusOpenFiltered // UDP port that never answered
+)
+
+// Convert status to string
+func (s PortStatus) String() string {
+	return [...]string{"Open", "Closed", "Filtered", "Error", "Open|Filtered"}[s]
+}
+
+// Outcome of probing a single port
+type PortResult struct {
+	Host     string
+	Address  string // IP that was probed
+	Port     int
+	Proto    Protocol
+	Status   PortStatus
+	Attempts int           // Connection attempts it took, more than 1 after retries
+	RTT      time.Duration // Connect time of the last attempt, 0 if nothing answered
+	Banner   string        // First thing an open TCP port said, when banners are on
+	Err      error         // *ScanError explaining a non-open result
+
+	conn        net.Conn // Probe connection kept open for the banner stage
+	releaseHost func()   // Frees the host connection slot conn holds, if any
+}
+
+// Snapshot of how far a scan has got
+type ScanProgress struct {
+	Total    int
+	Probed   int
+	Open     int
+	Closed   int
+	Filtered int // Includes UDP ports that are open|filtered
+	Errors   int
+	Retries  int // Extra attempts made on timed-out ports
+	Elapsed  time.Duration
+}
+
+// Share of ports probed so far, 0-100
+func (p ScanProgress) Percent() float64 {
+	if p.Total == 0 {
+		return 100
+	}
+	return float64(p.Probed) / float64(p.Total) * 100
+}
+
+// Probing speed in ports per second
+func (p ScanProgress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Probed) / p.Elapsed.Seconds()
+}
+
+// Estimated time left at the current rate
+func (p ScanProgress) Remaining() time.Duration {
+	rate := p.Rate()
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Probed) / rate * float64(time.Second))
+}
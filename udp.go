@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Requests that make common UDP services answer. Services that only
+// respond to a well-formed query would otherwise look open|filtered.
+var udpPayloads = map[int][]byte{
+	// DNS: standard query for the root NS records
+	53: {
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x02, 0x00, 0x01,
+	},
+	// TFTP: read request, any reply (even an error) means it's listening
+	69: []byte("\x00\x01probe\x00octet\x00"),
+	// NTP: version 3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// NetBIOS: unicast node status request for "*", no broadcast flag
+	137: append(append([]byte{
+		0x80, 0xf0, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20,
+		'C', 'K'}, []byte(strings.Repeat("A", 30))...),
+		0x00, 0x00, 0x21, 0x00, 0x01),
+	// SNMP: v1 get-request for sysDescr.0 with community "public"
+	161: {
+		0x30, 0x29, 0x02, 0x01, 0x00, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69,
+		0x63, 0xa0, 0x1c, 0x02, 0x04, 0x12, 0x34, 0x56, 0x78, 0x02, 0x01, 0x00,
+		0x02, 0x01, 0x00, 0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01,
+		0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+	},
+	// SSDP: UPnP discovery
+	1900: []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: ssdp:all\r\n\r\n"),
+}
+
+// Payload to send to a UDP port, a single zero byte when we don't know the protocol
+func udpPayload(port int) []byte {
+	if payload, exists := udpPayloads[port]; exists {
+		return payload
+	}
+	return []byte{0}
+}
+
+// Probe a UDP port. Any reply means open, an ICMP port unreachable means
+// closed and silence can't be told apart from a firewall, so it's open|filtered.
+func probeUDP(ctx context.Context, dialer Dialer, host string, port int, timeout time.Duration) (PortStatus, error) {
+	// Connected socket so ICMP errors are reported back to us
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return dialFailure(host, port, err)
+	}
+	defer conn.Close()
+
+	// Stop waiting at the timeout or as soon as the scan is cancelled
+	conn.SetDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	// Send the protocol-specific question
+	_, err = conn.Write(udpPayload(port))
+	if err == nil {
+		// Any answer at all means something is listening
+		buffer := make([]byte, 1500)
+		_, err = conn.Read(buffer)
+		if err == nil {
+			return StatusOpen, nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return StatusError, &ScanError{Host: host, Port: port, Message: "cancelled", Kind: ErrCancelled, Err: ctx.Err()}
+	}
+
+	// Linux turns ICMP port unreachable into a refused read
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return StatusClosed, &ScanError{Host: host, Port: port, Message: "port unreachable", Err: err}
+	}
+
+	if isTimeoutError(err) {
+		return StatusOpenFiltered, &ScanError{Host: host, Port: port, Message: "no response", Err: err}
+	}
+
+	return dialFailure(host, port, err)
+}
@@ -1,464 +1,1060 @@
-package main
-
-import (
-	"bufio"
-	"context"
-	"fmt"
-	"math"
-	"os"
-	"os/signal"
-	"strconv"
-	"strings"
-	"time"
-)
-
-// Shows a progress bar during scan
-func displayProgress(done chan bool, total int) {
-	start := time.Now()
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	counter := 0
-	barWidth := 40
-
-	for {
-		select {
-		case <-done:
-			// Finish with 100% bar
-			printProgressBar(barWidth, 100, time.Since(start), total, total)
-			fmt.Println()
-			return
-		case <-ticker.C:
-			counter++
-			// Estimate progress based on typical scan time
-			elapsed := time.Since(start)
-			estimatedTotal := 5 * time.Second
-			if total > 1000 {
-				estimatedTotal = 30 * time.Second
-			} else if total > 100 {
-				estimatedTotal = 15 * time.Second
-			}
-
-			// Keep progress under 100% until we're actually done
-			progress := float64(elapsed) / float64(estimatedTotal)
-			if progress > 0.99 {
-				progress = 0.99
-			}
-
-			// Calculate estimated ports done
-			portsCompleted := int(float64(total) * progress)
-
-			// Update the bar
-			printProgressBar(barWidth, progress*100, elapsed, portsCompleted, total)
-		}
-	}
-}
-
-// Draws the actual progress bar UI
-func printProgressBar(width int, percent float64, elapsed time.Duration, completed, total int) {
-	// Calculate filled positions
-	filled := int(percent / 100 * float64(width))
-	if filled > width {
-		filled = width
-	}
-
-	// Create the visual bar
-	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
-
-	// Calculate speed
-	portsPerSecond := float64(completed) / math.Max(elapsed.Seconds(), 0.001)
-	if math.IsNaN(portsPerSecond) || math.IsInf(portsPerSecond, 0) {
-		portsPerSecond = 0
-	}
-
-	// Estimate time remaining
-	var remaining string
-	if percent < 100 {
-		remainingPorts := total - completed
-		remainingTime := time.Duration(float64(remainingPorts)/math.Max(portsPerSecond, 0.001)) * time.Second
-		remaining = fmt.Sprintf(", ~%s remaining", formatDuration(remainingTime))
-	} else {
-		remaining = ", done!"
-	}
-
-	// Print everything
-	fmt.Printf("\r[%s] %.1f%% (%d/%d ports, %.1f ports/sec%s)    ",
-		bar, percent, completed, total, portsPerSecond, remaining)
-}
-
-// Main CLI interface
-func runInteractiveMode() {
-	scanner := bufio.NewScanner(os.Stdin)
-
-	// Start with help
-	printUIHelp()
-
-	// Main command loop
-	for {
-		fmt.Print("\nportscanner> ")
-		if !scanner.Scan() {
-			break
-		}
-
-		input := scanner.Text()
-		args := strings.Fields(input)
-
-		if len(args) == 0 {
-			continue
-		}
-
-		command := strings.ToLower(args[0])
-
-		switch command {
-		case "exit", "quit":
-			fmt.Println("Exiting port scanner. Goodbye!")
-			return
-
-		case "help":
-			printUIHelp()
-
-		case "scan":
-			handleUIScanCommand(args)
-
-		case "ping":
-			handleUIPingCommand(args)
-
-		case "banner":
-			handleUIBannerCommand(args)
-
-		case "range":
-			handleUIRangeCommand(args)
-
-		case "web":
-			fmt.Println("Starting web interface at http://localhost:8080")
-			fmt.Println("Press Ctrl+C to exit")
-			startWebServer()
-
-		default:
-			fmt.Printf("Unknown command: %s\nType 'help' for available commands\n", command)
-		}
-	}
-}
-
-// Shows available commands
-func printUIHelp() {
-	help := `
-Available Commands:
-------------------
-  scan <host> [start] [end] [threads] [timeout]
-      Scan a host for open ports
-      Example: scan google.com 1 1000 100 500
-      
-  ping <host>
-      Check if a host is alive
-      Example: ping 192.168.1.1
-      
-  banner <host> <port>
-      Grab a service banner from a specific port
-      Example: banner example.com 80
-      
-  range <start-end> [start] [end] [threads]
-      Scan an IP range
-      Example: range 192.168.1.1-192.168.1.10 1 100
-      
-  web
-      Start the web interface on port 8080
-      
-  clear
-      Clear the screen
-      
-  help
-      Show this help menu
-      
-  exit, quit
-      Exit the program
-      
-Go Features Showcased:
----------------------
-* Goroutines - Lightweight threads for concurrent scanning
-* Channels - Communication between concurrent operations
-* Select - Elegant handling of multiple events
-* Context - Clean cancellation and timeouts
-* Interfaces - Extensible service detection
-* Defer - Resource cleanup guarantees
-* Error handling - Explicit error checking and propagation
-`
-	fmt.Println(help)
-}
-
-// Handles the scan command
-func handleUIScanCommand(args []string) {
-	if len(args) < 2 {
-		fmt.Println("Usage: scan <host> [start] [end] [threads] [timeout]")
-		return
-	}
-
-	host := args[1]
-	startPort := 1
-	endPort := 1000
-	threads := 100
-	timeout := 500
-
-	// Parse optional args
-	if len(args) >= 3 {
-		var err error
-		startPort, err = strconv.Atoi(args[2])
-		if err != nil {
-			fmt.Println("Invalid start port, using default (1)")
-			startPort = 1
-		}
-	}
-
-	if len(args) >= 4 {
-		var err error
-		endPort, err = strconv.Atoi(args[3])
-		if err != nil {
-			fmt.Println("Invalid end port, using default (1000)")
-			endPort = 1000
-		}
-	}
-
-	if len(args) >= 5 {
-		var err error
-		threads, err = strconv.Atoi(args[4])
-		if err != nil {
-			fmt.Println("Invalid thread count, using default (100)")
-			threads = 100
-		}
-	}
-
-	if len(args) >= 6 {
-		var err error
-		timeout, err = strconv.Atoi(args[5])
-		if err != nil {
-			fmt.Println("Invalid timeout, using default (500ms)")
-			timeout = 500
-		}
-	}
-
-	// Sanity checks
-	if startPort < 1 || startPort > 65535 {
-		fmt.Println("Start port must be between 1 and 65535")
-		return
-	}
-
-	if endPort < 1 || endPort > 65535 || endPort < startPort {
-		fmt.Println("End port must be between start port and 65535")
-		return
-	}
-
-	fmt.Printf("\nStarting port scan on host %s (ports %d-%d)\n", host, startPort, endPort)
-	fmt.Printf("Using %d threads with %dms timeout\n\n", threads, timeout)
-
-	// Support cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Ctrl+C handler
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt)
-		<-sigChan
-		fmt.Println("\nCancelling scan...")
-		cancel()
-	}()
-
-	// Create and configure scanner
-	scanner := NewScanner(
-		WithTarget(host),
-		WithPortRange(startPort, endPort),
-		WithThreads(threads),
-		WithTimeout(time.Duration(timeout)*time.Millisecond),
-		WithProgress(true),
-		WithContext(ctx),
-	)
-
-	// Run the scan
-	openPorts, err := scanner.Scan()
-	if err != nil {
-		fmt.Printf("\nScan error: %v\n", err)
-		return
-	}
-
-	// Show results
-	fmt.Printf("\nScan completed for %s: %d open ports found\n", host, len(openPorts))
-	if len(openPorts) > 0 {
-		fmt.Printf("Open ports on %s: ", host)
-		for i, port := range openPorts {
-			service := getServiceName(port)
-			if i > 0 {
-				fmt.Print(", ")
-			}
-			fmt.Printf("%d (%s)", port, service)
-		}
-		fmt.Println()
-
-		// Try to identify OS
-		fmt.Printf("OS Detection: %s\n", guessOS(openPorts))
-	}
-}
-
-// Handles the ping command
-func handleUIPingCommand(args []string) {
-	if len(args) < 2 {
-		fmt.Println("Usage: ping <host>")
-		return
-	}
-
-	host := args[1]
-	fmt.Printf("Pinging %s... ", host)
-
-	ctx := context.Background()
-	if isHostAlive(ctx, host, 2*time.Second) {
-		fmt.Println("Host is up!")
-	} else {
-		fmt.Println("Host appears to be down.")
-	}
-}
-
-// Handles the banner grab command
-func handleUIBannerCommand(args []string) {
-	if len(args) < 3 {
-		fmt.Println("Usage: banner <host> <port>")
-		return
-	}
-
-	host := args[1]
-	port, err := strconv.Atoi(args[2])
-	if err != nil || port < 1 || port > 65535 {
-		fmt.Println("Port must be a number between 1 and 65535")
-		return
-	}
-
-	fmt.Printf("Grabbing banner from %s:%d...\n", host, port)
-	ctx := context.Background()
-	banner, err := grabBanner(ctx, host, port, 5*time.Second)
-
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
-	}
-
-	if banner != "" {
-		fmt.Printf("Banner: %s\n", banner)
-	} else {
-		fmt.Println("Could not retrieve banner (port may be closed or no banner available)")
-	}
-}
-
-// Handles the IP range scanning command
-func handleUIRangeCommand(args []string) {
-	if len(args) < 2 {
-		fmt.Println("Usage: range <start-end> [start] [end] [threads]")
-		return
-	}
-
-	ipRange := args[1]
-	startPort := 1
-	endPort := 100
-	threads := 100
-
-	// Parse optional args
-	if len(args) >= 3 {
-		var err error
-		startPort, err = strconv.Atoi(args[2])
-		if err != nil {
-			fmt.Println("Invalid start port, using default (1)")
-			startPort = 1
-		}
-	}
-
-	if len(args) >= 4 {
-		var err error
-		endPort, err = strconv.Atoi(args[3])
-		if err != nil {
-			fmt.Println("Invalid end port, using default (100)")
-			endPort = 100
-		}
-	}
-
-	if len(args) >= 5 {
-		var err error
-		threads, err = strconv.Atoi(args[4])
-		if err != nil {
-			fmt.Println("Invalid thread count, using default (100)")
-			threads = 100
-		}
-	}
-
-	// Get list of IPs from range
-	hosts, err := expandIPRange(ipRange)
-	if err != nil {
-		fmt.Printf("Error expanding IP range: %v\n", err)
-		return
-	}
-
-	fmt.Printf("Scanning %d hosts in range %s (ports %d-%d)\n",
-		len(hosts), ipRange, startPort, endPort)
-
-	// Support cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Ctrl+C handler
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt)
-		<-sigChan
-		fmt.Println("\nCancelling scan...")
-		cancel()
-	}()
-
-	// Scan each host
-	for _, host := range hosts {
-		// Check if canceled
-		select {
-		case <-ctx.Done():
-			fmt.Println("Scan cancelled.")
-			return
-		default:
-			// Continue
-		}
-
-		// Check if host is alive first
-		fmt.Printf("\nChecking if %s is alive... ", host)
-		if !isHostAlive(ctx, host, 500*time.Millisecond) {
-			fmt.Println("Host appears to be down, skipping.")
-			continue
-		}
-		fmt.Println("Host is up!")
-
-		// Configure scanner
-		scanner := NewScanner(
-			WithTarget(host),
-			WithPortRange(startPort, endPort),
-			WithThreads(threads),
-			WithTimeout(500*time.Millisecond),
-			WithProgress(true),
-			WithContext(ctx),
-		)
-
-		// Run the scan
-		fmt.Printf("Scanning %s (ports %d-%d)...\n", host, startPort, endPort)
-		openPorts, err := scanner.Scan()
-		if err != nil {
-			fmt.Printf("Scan error: %v\n", err)
-			continue
-		}
-
-		// Show results
-		if len(openPorts) > 0 {
-			fmt.Printf("Open ports on %s: ", host)
-			for i, port := range openPorts {
-				service := getServiceName(port)
-				if i > 0 {
-					fmt.Print(", ")
-				}
-				fmt.Printf("%d (%s)", port, service)
-			}
-			fmt.Println()
-		} else {
-			fmt.Printf("No open ports found on %s\n", host)
-		}
-	}
-}
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shows a progress bar during scan
+func displayProgress(done chan bool, snapshot func() ScanProgress) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	barWidth := 40
+
+	for {
+		select {
+		case <-done:
+			// Finish with the final numbers
+			printProgressBar(barWidth, snapshot(), true)
+			fmt.Println()
+			done <- true
+			return
+		case <-ticker.C:
+			// Update the bar from what the workers actually did
+			printProgressBar(barWidth, snapshot(), false)
+		}
+	}
+}
+
+// Draws the actual progress bar UI
+func printProgressBar(width int, progress ScanProgress, finished bool) {
+	percent := progress.Percent()
+
+	// Calculate filled positions
+	filled := int(percent / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+
+	// Create the visual bar
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+
+	// Calculate speed
+	portsPerSecond := progress.Rate()
+	if math.IsNaN(portsPerSecond) || math.IsInf(portsPerSecond, 0) {
+		portsPerSecond = 0
+	}
+
+	// Estimate time remaining
+	var remaining string
+	if !finished {
+		if portsPerSecond > 0 {
+			remaining = fmt.Sprintf(", ~%s remaining", formatDuration(progress.Remaining()))
+		} else {
+			remaining = ", estimating..."
+		}
+	} else {
+		remaining = ", done!"
+	}
+
+	// Print everything
+	fmt.Printf("\r[%s] %.1f%% (%d/%d ports, %d open, %d filtered, %.1f ports/sec%s)    ",
+		bar, percent, progress.Probed, progress.Total, progress.Open, progress.Filtered, portsPerSecond, remaining)
+}
+
+// Main CLI interface
+func runInteractiveMode() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Start with help
+	printUIHelp()
+
+	// Main command loop
+	for {
+		fmt.Print("\nportscanner> ")
+		if !scanner.Scan() {
+			break
+		}
+
+		input := scanner.Text()
+		args := strings.Fields(input)
+
+		if len(args) == 0 {
+			continue
+		}
+
+		command := strings.ToLower(args[0])
+
+		switch command {
+		case "exit", "quit":
+			fmt.Println("Exiting port scanner. Goodbye!")
+			return
+
+		case "help":
+			printUIHelp()
+
+		case "scan":
+			handleUIScanCommand(args, nil)
+
+		case "ping":
+			handleUIPingCommand(args)
+
+		case "banner":
+			handleUIBannerCommand(args)
+
+		case "range":
+			handleUIRangeCommand(args, nil)
+
+		case "resume":
+			handleUIResumeCommand(args)
+
+		case "web":
+			fmt.Println("Starting web interface at http://localhost:8080")
+			fmt.Println("Press Ctrl+C to exit")
+			log.Fatal(startWebServer(":8080"))
+
+		default:
+			fmt.Printf("Unknown command: %s\nType 'help' for available commands\n", command)
+		}
+	}
+}
+
+// Shows available commands
+func printUIHelp() {
+	help := `
+Available Commands:
+------------------
+  scan <host> [start] [end] [threads] [timeout] [options]
+      Scan a host for open ports
+      Example: scan google.com 1 1000 100 500
+      Example: scan 192.168.1.1 ports=22,80,443,8000-8100,U:53
+      Example: scan 192.168.1.1 top=100
+      Example: scan 192.168.1.1 ports=53,123,161 proto=udp
+      Example: scan 192.168.1.1 1 1000 rate=200 hostconns=20
+      
+  ping <host> [options]
+      Check if a host is alive
+      Example: ping 192.168.1.1
+      Example: ping 192.168.1.1 discover=icmp,arp
+      
+  banner <host> <port> [options]
+      Grab a service banner from a specific port
+      Example: banner example.com 80
+      
+  range <targets> [start] [end] [threads] [options]
+      Scan many hosts: CIDR blocks, ranges, IPs and hostnames separated
+      by commas, @file to read targets from a file, !target to skip one
+      Example: range 192.168.1.1-192.168.1.10 1 100
+      Example: range 10.0.0.0/16,!10.0.5.0/24 top=100
+      Example: range 192.168.1.250-192.168.2.10,example.com top=1000
+      Example: range @hosts.txt exclude=@skip.txt 1 1024
+      Example: range 2001:db8::/120 1 1024
+      Example: range 10.0.0.0/16 top=100 checkpoint=big.json
+      
+  resume <file>
+      Continue a scan or range command saved with checkpoint=<file>,
+      skipping the ports it already probed
+      Example: resume big.json
+      
+  web
+      Start the web interface on port 8080
+      
+  clear
+      Clear the screen
+      
+  help
+      Show this help menu
+
+Options (name=value, after the other arguments):
+  ports=<spec>   Ports to scan instead of start/end, e.g. 22,80,8000-8100,U:53
+  top=<n>        Scan the n most common ports
+  proto=<p>      Scan the ports over tcp, udp or both (default tcp)
+  addrs=all      Scan every IPv4 and IPv6 address of a hostname separately
+  dns=<server>   Resolve names with this DNS server, e.g. 10.0.0.53 or 10.0.0.53:5353
+  rdns=off       Skip reverse DNS lookups of IP targets
+  source=<ip>    Send probes from this local address
+  iface=<name>   Send probes from this network interface's addresses
+  sport=<ports>  Use this source port or range, e.g. 53 or 40000-40100
+  proxy=<url>    Connect through a proxy: socks5://[user:pass@]host:port,
+                 socks5h:// to resolve names at the proxy, or http://host:port
+  random=<seed>  Probe hosts and ports in a shuffled, reproducible order
+                 (leave the seed empty to pick one)
+  hosts=<n>      Scan up to n hosts of a range at the same time (default 8)
+  exclude=<targets>
+                 Skip these hosts when scanning a range
+  discover=<methods>
+                 How ping and range find live hosts: tcp, icmp, arp, or
+                 several like icmp,tcp (default tcp). none scans every host.
+  aliveports=<ports>
+                 Ports tcp discovery connects to (default 80,443,22,3389)
+  rate=<n>       Limit to n probes per second across all threads
+  hostconns=<n>  Limit concurrent connections to any one host
+  delay=<ms>     Wait at least this long between probes to the same host
+  adaptive=<min>-<max>
+                 Tune each host's timeout from measured round trips (ms)
+  retries=<n>    Try ports that time out up to n more times
+  backoff=<ms>   Wait before the first retry, doubling each time (default 200)
+  banners=<n>    Grab banners from up to n open ports at once (default 10,
+                 0 to skip banners)
+  bannertimeout=<ms>
+                 How long to wait for a banner (default: the scan timeout)
+  reuse=on       Read banners on the connection that found the port open
+                 instead of connecting again
+  autothreads=on Start with a few threads and tune how many run at once to
+                 the network, up to the thread count
+  nofile=raise   Raise the open file limit if it's too low for the threads,
+                 instead of running fewer probes at once
+  checkpoint=<file>
+                 Save progress to this file every few seconds so an
+                 interrupted scan or range can be resumed
+      
+  exit, quit
+      Exit the program
+      
+Go Features Showcased:
+---------------------
+* Goroutines - Lightweight threads for concurrent scanning
+* Channels - Communication between concurrent operations
+* Select - Elegant handling of multiple events
+* Context - Clean cancellation and timeouts
+* Interfaces - Extensible service detection
+* Defer - Resource cleanup guarantees
+* Error handling - Explicit error checking and propagation
+`
+	fmt.Println(help)
+}
+
+// Handles the scan command, skipping the work in resume if it's set
+func handleUIScanCommand(args []string, resume *Checkpoint) {
+	command := args
+	args, opts := splitUIOptions(args)
+	if len(args) < 2 {
+		fmt.Println("Usage: scan <host> [start] [end] [threads] [timeout] [options] (see help for options)")
+		return
+	}
+
+	host := normalizeHost(args[1])
+	startPort := 1
+	endPort := 1000
+	threads := 100
+	timeout := 500
+
+	// Parse optional args
+	if len(args) >= 3 {
+		var err error
+		startPort, err = strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Println("Invalid start port, using default (1)")
+			startPort = 1
+		}
+	}
+
+	if len(args) >= 4 {
+		var err error
+		endPort, err = strconv.Atoi(args[3])
+		if err != nil {
+			fmt.Println("Invalid end port, using default (1000)")
+			endPort = 1000
+		}
+	}
+
+	if len(args) >= 5 {
+		var err error
+		threads, err = strconv.Atoi(args[4])
+		if err != nil {
+			fmt.Println("Invalid thread count, using default (100)")
+			threads = 100
+		}
+	}
+
+	if len(args) >= 6 {
+		var err error
+		timeout, err = strconv.Atoi(args[5])
+		if err != nil {
+			fmt.Println("Invalid timeout, using default (500ms)")
+			timeout = 500
+		}
+	}
+
+	// Sanity checks
+	if startPort < 1 || startPort > 65535 {
+		fmt.Println("Start port must be between 1 and 65535")
+		return
+	}
+
+	if endPort < 1 || endPort > 65535 || endPort < startPort {
+		fmt.Println("End port must be between start port and 65535")
+		return
+	}
+
+	// Port spec options override start/end
+	ports, err := uiPortTargets(opts, startPort, endPort)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// Rate limits and timeout tuning
+	tuning, err := uiTuningOptions(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("\nStarting port scan on host %s (%s)\n", host, describePorts(ports))
+	if strings.EqualFold(opts["autothreads"], "on") {
+		fmt.Printf("Using up to %d threads, tuned as the scan goes, with %dms timeout\n\n", threads, timeout)
+	} else {
+		fmt.Printf("Using %d threads with %dms timeout\n\n", threads, timeout)
+	}
+
+	// Support cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Ctrl+C handler
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt)
+		<-sigChan
+		fmt.Println("\nCancelling scan...")
+		cancel()
+	}()
+
+	// Resolve once up front, dual-stack hosts can be scanned on every address
+	host = normalizeHost(host)
+	entries, err := uiDNSCache(opts).hostEntries(ctx, host, strings.ToLower(opts["addrs"]) == "all")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	addresses := []string{}
+	for _, entry := range entries {
+		addresses = append(addresses, entry.address)
+		if entry.address != host {
+			fmt.Printf("Scanning address %s (%s)\n", entry.address, addressFamily(entry.address))
+		}
+	}
+
+	// Create and configure scanner
+	options := []ScannerOption{
+		WithTargets(addresses...),
+		WithPorts(ports),
+		WithThreads(threads),
+		WithTimeout(time.Duration(timeout) * time.Millisecond),
+		WithProgress(true),
+		WithContext(ctx),
+	}
+	if seed, ok := uiRandomSeed(opts); ok {
+		options = append(options, WithRandomOrder(seed))
+	}
+	options = append(options, uiCheckpointOptions(opts, command, resume)...)
+	scanner := NewScanner(append(options, tuning...)...)
+
+	// Run the scan, reporting open ports as they turn up
+	openPorts := make(map[string][]int)
+	openFiltered := 0
+	for result := range scanner.Stream() {
+		if result.Status == StatusOpenFiltered {
+			openFiltered++
+		}
+		if result.Status == StatusOpen {
+			openPorts[result.Host] = append(openPorts[result.Host], result.Port)
+			if len(addresses) > 1 {
+				fmt.Printf("[%s] ", result.Host)
+			}
+			printOpenPort(PortInfo{
+				Port:    result.Port,
+				Proto:   result.Proto,
+				Service: identifyService(result.Port, result.Banner),
+				Banner:  result.Banner,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("\nScan error: %v\n", err)
+		printUIResumeHint(opts)
+		return
+	}
+
+	// Nothing getting through isn't the same as nothing being open
+	if failure := scanner.Stats().Failure(); failure != nil {
+		failure.Host = host
+		fmt.Printf("\nScan error: %v\n", failure)
+		for _, line := range formatScanStats(scanner.Stats()) {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	// Show results for each address
+	for _, address := range addresses {
+		label := host
+		if address != host {
+			label = fmt.Sprintf("%s [%s, %s]", host, address, addressFamily(address))
+		}
+
+		found := openPorts[address]
+		sort.Ints(found)
+		fmt.Printf("\nScan completed for %s: %d open ports found\n", label, len(found))
+		if address == host {
+			if names := scanner.ReverseNames(address); len(names) > 0 {
+				fmt.Printf("Reverse DNS: %s\n", strings.Join(names, ", "))
+			}
+		}
+		fmt.Println(formatRTTStats(scanner.RTTStats(address)))
+		if len(found) > 0 {
+			printHostOpenPorts(label, found)
+
+			// Try to identify OS
+			fmt.Printf("OS Detection: %s\n", guessOS(found))
+		}
+	}
+
+	if openFiltered > 0 {
+		fmt.Printf("%d UDP ports open|filtered (no response, may be open or firewalled)\n", openFiltered)
+	}
+
+	// What it took to get here
+	fmt.Println()
+	for _, line := range formatScanStats(scanner.Stats()) {
+		fmt.Println(line)
+	}
+}
+
+// Prints an open port along with its banner
+func printOpenPort(info PortInfo) {
+	port := info.Port
+	service := info.Service
+
+	// UDP services don't greet us
+	if info.Proto == ProtoUDP {
+		fmt.Printf("Port %d/udp is open (%s)\n", port, service)
+		return
+	}
+
+	banner := info.Banner
+	if banner != "" {
+		fmt.Printf("Port %d is open (%s): %s\n", port, service, banner)
+	} else {
+		fmt.Printf("Port %d is open (%s)\n", port, service)
+	}
+}
+
+// Pulls name=value options out of the command arguments
+func splitUIOptions(args []string) ([]string, map[string]string) {
+	positional := []string{}
+	opts := make(map[string]string)
+
+	for _, arg := range args {
+		name, value, found := strings.Cut(arg, "=")
+		if found && name != "" {
+			opts[strings.ToLower(name)] = value
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+
+	return positional, opts
+}
+
+// Reads the random=<seed> option, an empty seed picks one from the clock
+func uiRandomSeed(opts map[string]string) (int64, bool) {
+	value, ok := opts["random"]
+	if !ok {
+		return 0, false
+	}
+
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		seed = time.Now().UnixNano()
+		fmt.Printf("Using random seed %d\n", seed)
+	}
+
+	return seed, true
+}
+
+// Reads the rate=, hostconns=, delay=, retries=, backoff=, banners=, bannertimeout=, reuse=, autothreads=, nofile= and adaptive= options
+func uiTuningOptions(opts map[string]string) ([]ScannerOption, error) {
+	options := []ScannerOption{}
+
+	// Helper for the non-negative numeric options
+	number := func(name string) (int, bool, error) {
+		value, ok := opts[name]
+		if !ok {
+			return 0, false, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, false, fmt.Errorf("invalid %s value %q", name, value)
+		}
+		return n, true, nil
+	}
+
+	rate, ok, err := number("rate")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		options = append(options, WithRateLimit(rate))
+	}
+
+	conns, ok, err := number("hostconns")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		options = append(options, WithMaxHostConns(conns))
+	}
+
+	delay, ok, err := number("delay")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		options = append(options, WithProbeDelay(time.Duration(delay)*time.Millisecond))
+	}
+
+	// Retries for timed-out ports, with an optional backoff in ms
+	retries, ok, err := number("retries")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		backoff, set, err := number("backoff")
+		if err != nil {
+			return nil, err
+		}
+		if !set {
+			backoff = 200
+		}
+		options = append(options, WithRetries(retries, time.Duration(backoff)*time.Millisecond))
+	}
+
+	// Banners are grabbed alongside the scan, banners=0 skips them
+	workers, ok, err := number("banners")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		workers = 10
+	}
+	readTimeout, _, err := number("bannertimeout")
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, WithBanners(workers, time.Duration(readTimeout)*time.Millisecond))
+	if value, ok := opts["reuse"]; ok {
+		switch strings.ToLower(value) {
+		case "on":
+			options = append(options, WithConnReuse())
+		case "off":
+			// The default
+		default:
+			return nil, fmt.Errorf("invalid reuse value %q (use on or off)", value)
+		}
+	}
+
+	// The thread count becomes a ceiling for the tuner
+	if value, ok := opts["autothreads"]; ok {
+		switch strings.ToLower(value) {
+		case "on":
+			options = append(options, WithAutoThreads())
+		case "off":
+			// The default
+		default:
+			return nil, fmt.Errorf("invalid autothreads value %q (use on or off)", value)
+		}
+	}
+
+	// A low open file limit caps the threads unless it may be raised
+	if value, ok := opts["nofile"]; ok {
+		if !strings.EqualFold(value, "raise") {
+			return nil, fmt.Errorf("invalid nofile value %q (use raise)", value)
+		}
+		options = append(options, WithRaiseFileLimit())
+	}
+
+	// Where probes leave from
+	if address, ok := opts["source"]; ok {
+		options = append(options, WithSourceAddress(address))
+	}
+	if iface, ok := opts["iface"]; ok {
+		options = append(options, WithInterface(iface))
+	}
+	if value, ok := opts["sport"]; ok {
+		low, high, err := uiSourcePorts(value)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithSourcePorts(low, high))
+	}
+
+	if proxy, ok := opts["proxy"]; ok {
+		options = append(options, WithProxy(proxy))
+	}
+
+	// Name resolution
+	if server, ok := opts["dns"]; ok {
+		options = append(options, WithDNSServer(server))
+	}
+	if value, ok := opts["rdns"]; ok {
+		switch strings.ToLower(value) {
+		case "on":
+			options = append(options, WithReverseDNS(true))
+		case "off":
+			options = append(options, WithReverseDNS(false))
+		default:
+			return nil, fmt.Errorf("invalid rdns value %q (use on or off)", value)
+		}
+	}
+
+	// Adaptive timeout bounds in ms, e.g. adaptive=50-2000
+	if value, ok := opts["adaptive"]; ok {
+		low, high, found := strings.Cut(value, "-")
+		minMs, err1 := strconv.Atoi(low)
+		maxMs, err2 := strconv.Atoi(high)
+		if !found || err1 != nil || err2 != nil || minMs < 1 || maxMs < minMs {
+			return nil, fmt.Errorf("invalid adaptive value %q (use min-max in ms, e.g. 50-2000)", value)
+		}
+		options = append(options, WithAdaptiveTimeout(
+			time.Duration(minMs)*time.Millisecond,
+			time.Duration(maxMs)*time.Millisecond,
+		))
+	}
+
+	return options, nil
+}
+
+// Parses a source port or range like 53 or 40000-40100
+func uiSourcePorts(value string) (int, int, error) {
+	low, high, found := strings.Cut(value, "-")
+	if !found {
+		high = low
+	}
+	minPort, err1 := strconv.Atoi(low)
+	maxPort, err2 := strconv.Atoi(high)
+	if err1 != nil || err2 != nil || minPort < 1 || maxPort > 65535 || maxPort < minPort {
+		return 0, 0, fmt.Errorf("invalid sport value %q (use a port or a range like 40000-40100)", value)
+	}
+	return minPort, maxPort, nil
+}
+
+// DNS cache for commands that resolve before scanning, names are left to proxies that resolve them
+func uiDNSCache(opts map[string]string) *dnsCache {
+	dns := newDNSCache(opts["dns"])
+	if proxy, err := parseProxy(opts["proxy"]); err == nil && proxy != nil {
+		dns.passNames = proxy.resolvesNames()
+	}
+	return dns
+}
+
+// Builds the source binding and proxy for commands that dial without a scanner
+func uiDialer(opts map[string]string) (*scanDialer, error) {
+	low, high := 0, 0
+	if value, ok := opts["sport"]; ok {
+		var err error
+		low, high, err = uiSourcePorts(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	source, err := newSourceBinding(opts["source"], opts["iface"], low, high)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := parseProxy(opts["proxy"])
+	if err != nil {
+		return nil, err
+	}
+	return newScanDialer(nil, source, proxy), nil
+}
+
+// Turns discover= and aliveports= into liveness check options, none skips the check
+func uiDiscoveryOptions(opts map[string]string) ([]ScannerOption, error) {
+	if strings.ToLower(opts["discover"]) == "none" {
+		return nil, nil
+	}
+
+	probes, err := parseDiscovery(opts["discover"], opts["aliveports"])
+	if err != nil {
+		return nil, err
+	}
+
+	return []ScannerOption{
+		WithAliveCheck(500 * time.Millisecond),
+		WithDiscovery(probes...),
+	}, nil
+}
+
+// Works out which ports to scan from ports=/top= options or a start/end range
+func uiPortTargets(opts map[string]string, startPort, endPort int) ([]PortTarget, error) {
+	targets := portRange(startPort, endPort)
+
+	var err error
+	if spec, ok := opts["ports"]; ok {
+		targets, err = parsePortSpec(spec)
+	} else if top, ok := opts["top"]; ok {
+		targets, err = parsePortSpec("top:" + top)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// proto=udp or proto=both
+	return applyProtocol(targets, opts["proto"])
+}
+
+// Handles the ping command
+func handleUIPingCommand(args []string) {
+	args, opts := splitUIOptions(args)
+	if len(args) < 2 {
+		fmt.Println("Usage: ping <host> [discover=<methods>] [aliveports=<ports>]")
+		return
+	}
+
+	host := normalizeHost(args[1])
+	if strings.ToLower(opts["discover"]) == "none" {
+		fmt.Println("Error: ping needs a discovery method")
+		return
+	}
+	probes, err := parseDiscovery(opts["discover"], opts["aliveports"])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	dialer, err := uiDialer(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// ICMP and ARP need an address to work with
+	ctx := context.Background()
+	entries, err := uiDNSCache(opts).hostEntries(ctx, host, false)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Pinging %s... ", host)
+	method, err := discoverHost(ctx, dialer, entries[0].address, 2*time.Second, probes)
+	switch {
+	case method != "":
+		fmt.Printf("Host is up! (%s)\n", method)
+	case err != nil:
+		fmt.Printf("Host appears to be down (%v)\n", err)
+	default:
+		fmt.Println("Host appears to be down.")
+	}
+}
+
+// Handles the banner grab command
+func handleUIBannerCommand(args []string) {
+	args, opts := splitUIOptions(args)
+	if len(args) < 3 {
+		fmt.Println("Usage: banner <host> <port> [source=<ip>] [iface=<name>] [sport=<port>] [proxy=<url>]")
+		return
+	}
+
+	host := normalizeHost(args[1])
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port < 1 || port > 65535 {
+		fmt.Println("Port must be a number between 1 and 65535")
+		return
+	}
+
+	dialer, err := uiDialer(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Grabbing banner from %s:%d...\n", host, port)
+	ctx := context.Background()
+	banner, err := grabBanner(ctx, dialer, host, port, 5*time.Second, 5*time.Second)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if banner != "" {
+		fmt.Printf("Banner: %s\n", banner)
+	} else {
+		fmt.Println("Could not retrieve banner (port may be closed or no banner available)")
+	}
+}
+
+// Handles the IP range scanning command, skipping the work in resume if it's set
+func handleUIRangeCommand(args []string, resume *Checkpoint) {
+	command := args
+	args, opts := splitUIOptions(args)
+	if len(args) < 2 {
+		fmt.Println("Usage: range <targets> [start] [end] [threads] [options] (see help for options)")
+		return
+	}
+
+	ipRange := args[1]
+	startPort := 1
+	endPort := 100
+	threads := 100
+
+	// Parse optional args
+	if len(args) >= 3 {
+		var err error
+		startPort, err = strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Println("Invalid start port, using default (1)")
+			startPort = 1
+		}
+	}
+
+	if len(args) >= 4 {
+		var err error
+		endPort, err = strconv.Atoi(args[3])
+		if err != nil {
+			fmt.Println("Invalid end port, using default (100)")
+			endPort = 100
+		}
+	}
+
+	if len(args) >= 5 {
+		var err error
+		threads, err = strconv.Atoi(args[4])
+		if err != nil {
+			fmt.Println("Invalid thread count, using default (100)")
+			threads = 100
+		}
+	}
+
+	// Sanity checks
+	if startPort < 1 || startPort > 65535 {
+		fmt.Println("Start port must be between 1 and 65535")
+		return
+	}
+
+	if endPort < 1 || endPort > 65535 || endPort < startPort {
+		fmt.Println("End port must be between start port and 65535")
+		return
+	}
+
+	// Port spec options override start/end
+	ports, err := uiPortTargets(opts, startPort, endPort)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// Rate limits and timeout tuning
+	tuning, err := uiTuningOptions(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// How to find live hosts
+	discovery, err := uiDiscoveryOptions(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// Check the seed before doing any work
+	seed, shuffled := uiRandomSeed(opts)
+
+	// Hosts scanned side by side
+	maxHosts := 8
+	if value, ok := opts["hosts"]; ok {
+		maxHosts, err = strconv.Atoi(value)
+		if err != nil || maxHosts < 1 {
+			fmt.Printf("Error: invalid hosts value %q\n", value)
+			return
+		}
+	}
+
+	// Parse the targets, hosts are generated as the scan goes
+	targets, err := parseTargetSpec(ipRange, opts["exclude"])
+	if err != nil {
+		fmt.Printf("Error parsing targets: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Scanning up to %d hosts in %s (%s)\n",
+		targets.Count(), ipRange, describePorts(ports))
+
+	// Support cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Ctrl+C handler
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt)
+		<-sigChan
+		fmt.Println("\nCancelling scan...")
+		cancel()
+	}()
+
+	// One shared pool for every host, skipping the dead ones
+	options := []ScannerOption{
+		WithPorts(ports),
+		WithThreads(threads),
+		WithTimeout(500 * time.Millisecond),
+		WithMaxHosts(maxHosts),
+		WithProgress(false), // Hosts finish in parallel, a bar would garble the output
+		WithContext(ctx),
+	}
+	if shuffled {
+		options = append(options, WithRandomOrder(seed))
+		fmt.Printf("Probing hosts and ports in random order (seed %d)\n", seed)
+	}
+	options = append(options, discovery...)
+	options = append(options, uiCheckpointOptions(opts, command, resume)...)
+	hosts := targets.Hosts()
+	if shuffled {
+		// Hosts come up in random order too, not only their ports
+		hosts = targets.ShuffledHosts(seed)
+	}
+	scanner := NewMultiScanner(hosts, append(options, tuning...)...)
+
+	// Report each host as it finishes
+	up, down, failed := 0, 0, 0
+	for result := range scanner.Results() {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("\nCouldn't scan %s: %v\n", formatHostLabel(result), result.Err)
+			if result.Stats.Probed > 0 {
+				for _, line := range formatScanStats(result.Stats) {
+					fmt.Println(line)
+				}
+			}
+			continue
+		}
+		if result.Down {
+			down++
+			fmt.Printf("\n%s appears to be down, skipped.\n", formatHostLabel(result))
+			continue
+		}
+		up++
+
+		fmt.Printf("\nFinished %s in %s", formatHostLabel(result), formatDuration(result.Duration))
+		if result.Resumed {
+			fmt.Print(" (before resuming)")
+		}
+		fmt.Println()
+		if len(result.Names) > 0 {
+			fmt.Printf("Reverse DNS: %s\n", strings.Join(result.Names, ", "))
+		}
+		openPorts := []int{}
+		for _, info := range result.Ports {
+			openPorts = append(openPorts, info.Port)
+			printOpenPort(info)
+		}
+		printHostOpenPorts(formatHostLabel(result), openPorts)
+		fmt.Println(formatRTTStats(result.RTT))
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Scan error: %v\n", err)
+		printUIResumeHint(opts)
+		return
+	}
+
+	fmt.Printf("\nRange scan complete: %d hosts up, %d down", up, down)
+	if failed > 0 {
+		fmt.Printf(", %d couldn't be scanned", failed)
+	}
+	fmt.Println()
+	fmt.Println(formatScanStats(scanner.Stats())[0])
+}
+
+// Turns checkpoint=<file> and a checkpoint being resumed into scanner options
+func uiCheckpointOptions(opts map[string]string, command []string, resume *Checkpoint) []ScannerOption {
+	options := []ScannerOption{}
+	if path := opts["checkpoint"]; path != "" {
+		options = append(options, WithCheckpoint(path, command...))
+	}
+	if resume != nil {
+		options = append(options, WithResume(resume))
+	}
+	return options
+}
+
+// Tells the user how to pick up an interrupted scan
+func printUIResumeHint(opts map[string]string) {
+	if path := opts["checkpoint"]; path != "" {
+		fmt.Printf("Progress saved to %s, continue with: resume %s\n", path, path)
+	}
+}
+
+// Handles the resume command, rerunning a saved scan or range command
+func handleUIResumeCommand(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: resume <checkpoint file>")
+		return
+	}
+
+	path := args[1]
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if len(cp.Command) == 0 {
+		fmt.Printf("Error: %s doesn't say which command to resume\n", path)
+		return
+	}
+
+	// Keep saving to the file we resumed from, even if it was moved
+	command := []string{}
+	for _, arg := range cp.Command {
+		if !strings.HasPrefix(strings.ToLower(arg), "checkpoint=") {
+			command = append(command, arg)
+		}
+	}
+	command = append(command, "checkpoint="+path)
+
+	fmt.Printf("Resuming %q, saved %s\n", strings.Join(cp.Command, " "), cp.Saved.Format("Jan 02 15:04:05"))
+	if cp.Complete {
+		fmt.Println("That scan already finished, showing its results again")
+	}
+
+	switch strings.ToLower(command[0]) {
+	case "scan":
+		handleUIScanCommand(command, cp)
+	case "range":
+		handleUIRangeCommand(command, cp)
+	default:
+		fmt.Printf("Error: %s was saved by %q, only scan and range can be resumed here\n", path, command[0])
+	}
+}
+
+// Prints the open ports found on one host
+func printHostOpenPorts(host string, openPorts []int) {
+	if len(openPorts) == 0 {
+		fmt.Printf("No open ports found on %s\n", host)
+		return
+	}
+
+	fmt.Printf("Open ports on %s: ", host)
+	for i, port := range openPorts {
+		service := getServiceName(port)
+		if i > 0 {
+			fmt.Print(", ")
+		}
+		fmt.Printf("%d (%s)", port, service)
+	}
+	fmt.Println()
+}